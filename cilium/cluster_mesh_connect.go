@@ -5,13 +5,15 @@ package provider
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"os"
 	"strings"
 	"time"
 
 	"github.com/cilium/cilium/cilium-cli/clustermesh"
+	"github.com/cilium/cilium/cilium-cli/defaults"
 
+	"github.com/hashicorp/terraform-plugin-framework-timeouts/resource/timeouts"
 	"github.com/hashicorp/terraform-plugin-framework/path"
 	"github.com/hashicorp/terraform-plugin-framework/resource"
 	"github.com/hashicorp/terraform-plugin-framework/resource/schema"
@@ -41,10 +43,13 @@ type CiliumClusterMeshConnectResource struct {
 type CiliumClusterMeshConnectResourceModel struct {
 	//SourceEndpoints      types.List `tfsdk:"source_endpoint"`
 	//DestinationEndpoints types.List `tfsdk:"destination_endpoint"`
-	DestinationContexts types.List   `tfsdk:"destination_contexts"`
-	Parallel            types.Int32  `tfsdk:"parallel"`
-	ConnectionMode      types.String `tfsdk:"connection_mode"`
-	Id                  types.String `tfsdk:"id"`
+	DestinationContexts types.List     `tfsdk:"destination_contexts"`
+	Parallel            types.Int32    `tfsdk:"parallel"`
+	ConnectionMode      types.String   `tfsdk:"connection_mode"`
+	Namespace           types.String   `tfsdk:"namespace"`
+	HelmRelease         types.String   `tfsdk:"helm_release"`
+	Id                  types.String   `tfsdk:"id"`
+	Timeouts            timeouts.Value `tfsdk:"timeouts"`
 }
 
 func (r *CiliumClusterMeshConnectResource) Metadata(ctx context.Context, req resource.MetadataRequest, resp *resource.MetadataResponse) {
@@ -52,14 +57,17 @@ func (r *CiliumClusterMeshConnectResource) Metadata(ctx context.Context, req res
 }
 
 func (r *CiliumClusterMeshConnectResource) Schema(ctx context.Context, req resource.SchemaRequest, resp *resource.SchemaResponse) {
+	namespace, helmRelease := releaseAttributes(func() *CiliumClient { return r.client })
 	resp.Schema = schema.Schema{
 		// This description is used by the documentation generator and the language server.
 		MarkdownDescription: "Cluster Mesh connection resource. This is equivalent to cilium cli: `cilium clustermesh connect` and `cilium clustermesh disconnect`: It manages the connections between two Kubernetes clusters.",
 
 		Attributes: map[string]schema.Attribute{
+			"namespace":    namespace,
+			"helm_release": helmRelease,
 			"destination_contexts": schema.ListAttribute{
 				ElementType:         types.StringType,
-				MarkdownDescription: "Kubernetes configuration contexts of destination clusters",
+				MarkdownDescription: "Kubernetes configuration contexts of destination clusters. They are looked up in the kube config of the provider (`config_path` or `config_content`, else `KUBECONFIG` or `~/.kube/config`)",
 				Optional:            true,
 				Computed:            true,
 				Default:             listdefault.StaticValue(types.ListNull(types.StringType)),
@@ -97,6 +105,14 @@ func (r *CiliumClusterMeshConnectResource) Schema(ctx context.Context, req resou
 				},
 			},
 		},
+		Blocks: map[string]schema.Block{
+			"timeouts": timeouts.Block(ctx, timeouts.Opts{
+				Create: true,
+				Read:   true,
+				Update: true,
+				Delete: true,
+			}),
+		},
 	}
 }
 
@@ -122,32 +138,50 @@ func (r *CiliumClusterMeshConnectResource) Configure(ctx context.Context, req re
 
 func (r *CiliumClusterMeshConnectResource) Create(ctx context.Context, req resource.CreateRequest, resp *resource.CreateResponse) {
 	var data CiliumClusterMeshConnectResourceModel
-	c := r.client
+
+	// Read Terraform plan data into the model
+	resp.Diagnostics.Append(req.Plan.Get(ctx, &data)...)
+
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	c := r.client.ForRelease(data.Namespace, data.HelmRelease)
 	if c == nil {
 		resp.Diagnostics.AddError("Client Error", "Unable to connect to kubernetes")
 		return
 	}
-	k8sClient, namespace, helm_release := c.client, c.namespace, c.helm_release
+	_, err := c.K8sClient()
+	if err != nil {
+		resp.Diagnostics.AddError("Client Error", fmt.Sprintf("Unable to connect to kubernetes: %s", err))
+		return
+	}
+	namespace, helm_release := c.namespace, c.helm_release
 	var params = clustermesh.Parameters{
-		Writer: os.Stdout,
+		Writer: c.LogWriter(ctx, "cilium_clustermesh_connection"),
 	}
 
-	// Read Terraform plan data into the model
-	resp.Diagnostics.Append(req.Plan.Get(ctx, &data)...)
-
+	createTimeout, diags := data.Timeouts.Create(ctx, defaults.StatusWaitDuration)
+	resp.Diagnostics.Append(diags...)
 	if resp.Diagnostics.HasError() {
 		return
 	}
+	ctx, cancel := context.WithTimeout(ctx, createTimeout)
+	defer cancel()
+
 	params.Namespace = namespace
 	params.HelmReleaseName = helm_release
+	params.ImpersonateAs = c.impersonate_as
+	params.ImpersonateGroups = c.impersonate_groups
 
 	params.DestinationContext = ValueList(ctx, data.DestinationContexts)
 	params.ConnectionMode = data.ConnectionMode.ValueString()
 	params.Parallel = int(data.Parallel.ValueInt32())
 
-	cm := clustermesh.NewK8sClusterMesh(k8sClient, params)
-	if err := cm.ConnectWithHelm(context.Background()); err != nil {
-		resp.Diagnostics.AddError("Client Error", fmt.Sprintf("Unable to connect cluster: %s", err))
+	if err := c.HelmMutation(ctx, func() error {
+		return c.ConnectClusterMesh(ctx, params)
+	}); err != nil {
+		resp.Diagnostics.AddError("Client Error", fmt.Sprintf("Unable to connect cluster: %s", interrupted(ctx, err)))
 		return
 	}
 
@@ -165,33 +199,68 @@ func (r *CiliumClusterMeshConnectResource) Create(ctx context.Context, req resou
 
 func (r *CiliumClusterMeshConnectResource) Read(ctx context.Context, req resource.ReadRequest, resp *resource.ReadResponse) {
 	var data CiliumClusterMeshConnectResourceModel
-	c := r.client
+
+	// Read Terraform prior state data into the model
+	resp.Diagnostics.Append(req.State.Get(ctx, &data)...)
+
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	c := r.client.ForRelease(data.Namespace, data.HelmRelease)
 	if c == nil {
 		resp.Diagnostics.AddError("Client Error", "Unable to connect to kubernetes")
 		return
 	}
-	k8sClient, namespace, helm_release := c.client, c.namespace, c.helm_release
+	k8sClient, err := c.K8sClient()
+	if errors.Is(err, errProviderConfigUnknown) {
+		// Keep the prior state until the provider configuration is known.
+		return
+	}
+	if err != nil {
+		resp.Diagnostics.AddError("Client Error", fmt.Sprintf("Unable to connect to kubernetes: %s", err))
+		return
+	}
+	namespace, helm_release := c.namespace, c.helm_release
 	var params = clustermesh.Parameters{
-		Writer: os.Stdout,
+		Writer: c.LogWriter(ctx, "cilium_clustermesh_connection"),
 	}
 
-	// Read Terraform prior state data into the model
-	resp.Diagnostics.Append(req.State.Get(ctx, &data)...)
-
+	readTimeout, diags := data.Timeouts.Read(ctx, 20*time.Second)
+	resp.Diagnostics.Append(diags...)
 	if resp.Diagnostics.HasError() {
 		return
 	}
+	ctx, cancel := context.WithTimeout(ctx, readTimeout)
+	defer cancel()
 
 	params.Namespace = namespace
 	params.HelmReleaseName = helm_release
+	params.ImpersonateAs = c.impersonate_as
+	params.ImpersonateGroups = c.impersonate_groups
 	params.Wait = true
-	params.WaitDuration = 20 * time.Second
+	params.WaitDuration = readTimeout
 
-	cm := clustermesh.NewK8sClusterMesh(k8sClient, params)
-	if _, err := cm.Status(context.Background()); err != nil {
+	snapshot, err := c.GetReleaseSnapshot()
+	if isNotFound(err) {
 		resp.State.RemoveResource(ctx)
 		return
 	}
+	if err != nil {
+		resp.Diagnostics.AddError("Client Error", fmt.Sprintf("Unable to read Cilium release: %s", interrupted(ctx, err)))
+		return
+	}
+	if !snapshot.ClusterMeshConnected() {
+		resp.State.RemoveResource(ctx)
+		return
+	}
+
+	// The clusters are connected: an unhealthy status must not plan to
+	// connect them again.
+	cm := clustermesh.NewK8sClusterMesh(k8sClient, params)
+	if _, err := cm.Status(ctx); err != nil {
+		resp.Diagnostics.AddWarning("ClusterMesh Status", fmt.Sprintf("Unable to determine status: %s", interrupted(ctx, err)))
+	}
 
 	// Save updated data into Terraform state
 	resp.Diagnostics.Append(resp.State.Set(ctx, &data)...)
@@ -199,30 +268,48 @@ func (r *CiliumClusterMeshConnectResource) Read(ctx context.Context, req resourc
 
 func (r *CiliumClusterMeshConnectResource) Update(ctx context.Context, req resource.UpdateRequest, resp *resource.UpdateResponse) {
 	var data CiliumClusterMeshConnectResourceModel
-	c := r.client
+
+	// Read Terraform plan data into the model
+	resp.Diagnostics.Append(req.Plan.Get(ctx, &data)...)
+
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	c := r.client.ForRelease(data.Namespace, data.HelmRelease)
 	if c == nil {
 		resp.Diagnostics.AddError("Client Error", "Unable to connect to kubernetes")
 		return
 	}
-	k8sClient, namespace, helm_release := c.client, c.namespace, c.helm_release
+	_, err := c.K8sClient()
+	if err != nil {
+		resp.Diagnostics.AddError("Client Error", fmt.Sprintf("Unable to connect to kubernetes: %s", err))
+		return
+	}
+	namespace, helm_release := c.namespace, c.helm_release
 	var params = clustermesh.Parameters{
-		Writer: os.Stdout,
+		Writer: c.LogWriter(ctx, "cilium_clustermesh_connection"),
 	}
 
-	// Read Terraform plan data into the model
-	resp.Diagnostics.Append(req.Plan.Get(ctx, &data)...)
-
+	updateTimeout, diags := data.Timeouts.Update(ctx, defaults.StatusWaitDuration)
+	resp.Diagnostics.Append(diags...)
 	if resp.Diagnostics.HasError() {
 		return
 	}
+	ctx, cancel := context.WithTimeout(ctx, updateTimeout)
+	defer cancel()
+
 	params.Namespace = namespace
 	params.DestinationContext = ValueList(ctx, data.DestinationContexts)
 	params.ConnectionMode = data.ConnectionMode.ValueString()
 	params.HelmReleaseName = helm_release
+	params.ImpersonateAs = c.impersonate_as
+	params.ImpersonateGroups = c.impersonate_groups
 
-	cm := clustermesh.NewK8sClusterMesh(k8sClient, params)
-	if err := cm.ConnectWithHelm(context.Background()); err != nil {
-		resp.Diagnostics.AddError("Client Error", fmt.Sprintf("Unable to connect clusters: %s", err))
+	if err := c.HelmMutation(ctx, func() error {
+		return c.ConnectClusterMesh(ctx, params)
+	}); err != nil {
+		resp.Diagnostics.AddError("Client Error", fmt.Sprintf("Unable to connect clusters: %s", interrupted(ctx, err)))
 		return
 	}
 
@@ -232,31 +319,48 @@ func (r *CiliumClusterMeshConnectResource) Update(ctx context.Context, req resou
 
 func (r *CiliumClusterMeshConnectResource) Delete(ctx context.Context, req resource.DeleteRequest, resp *resource.DeleteResponse) {
 	var data CiliumClusterMeshConnectResourceModel
-	c := r.client
+
+	//// Read Terraform prior state data into the model
+	resp.Diagnostics.Append(req.State.Get(ctx, &data)...)
+
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	c := r.client.ForRelease(data.Namespace, data.HelmRelease)
 	if c == nil {
 		resp.Diagnostics.AddError("Client Error", "Unable to connect to kubernetes")
 		return
 	}
-	k8sClient, namespace, helm_release := c.client, c.namespace, c.helm_release
+	_, err := c.K8sClient()
+	if err != nil {
+		resp.Diagnostics.AddError("Client Error", fmt.Sprintf("Unable to connect to kubernetes: %s", err))
+		return
+	}
+	namespace, helm_release := c.namespace, c.helm_release
 	var params = clustermesh.Parameters{
-		Writer: os.Stdout,
+		Writer: c.LogWriter(ctx, "cilium_clustermesh_connection"),
 	}
 
-	//// Read Terraform prior state data into the model
-	resp.Diagnostics.Append(req.State.Get(ctx, &data)...)
-
+	deleteTimeout, diags := data.Timeouts.Delete(ctx, defaults.StatusWaitDuration)
+	resp.Diagnostics.Append(diags...)
 	if resp.Diagnostics.HasError() {
 		return
 	}
+	ctx, cancel := context.WithTimeout(ctx, deleteTimeout)
+	defer cancel()
 
 	params.Namespace = namespace
 	params.HelmReleaseName = helm_release
+	params.ImpersonateAs = c.impersonate_as
+	params.ImpersonateGroups = c.impersonate_groups
 	params.ConnectionMode = data.ConnectionMode.ValueString()
 	params.DestinationContext = ValueList(ctx, data.DestinationContexts)
 
-	cm := clustermesh.NewK8sClusterMesh(k8sClient, params)
-	if err := cm.DisconnectWithHelm(context.Background()); err != nil {
-		resp.Diagnostics.AddError("Client Error", fmt.Sprintf("Unable to disconnect clusters: %s", err))
+	if err := c.HelmMutation(ctx, func() error {
+		return c.DisconnectClusterMesh(ctx, params)
+	}); err != nil {
+		resp.Diagnostics.AddError("Client Error", fmt.Sprintf("Unable to disconnect clusters: %s", interrupted(ctx, err)))
 		return
 	}
 }
@@ -5,12 +5,14 @@ package provider
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"os"
 	"time"
 
 	"github.com/cilium/cilium/cilium-cli/clustermesh"
+	"github.com/cilium/cilium/cilium-cli/defaults"
 
+	"github.com/hashicorp/terraform-plugin-framework-timeouts/resource/timeouts"
 	"github.com/hashicorp/terraform-plugin-framework/path"
 	"github.com/hashicorp/terraform-plugin-framework/resource"
 	"github.com/hashicorp/terraform-plugin-framework/resource/schema"
@@ -22,6 +24,9 @@ import (
 	"github.com/hashicorp/terraform-plugin-log/tflog"
 )
 
+// clustermeshEnableWithHelm is replaced in tests.
+var clustermeshEnableWithHelm = clustermesh.EnableWithHelm
+
 // Ensure provider defined types fully satisfy framework interfaces.
 var _ resource.Resource = &CiliumClusterMeshEnableResource{}
 var _ resource.ResourceWithImportState = &CiliumClusterMeshEnableResource{}
@@ -37,10 +42,13 @@ type CiliumClusterMeshEnableResource struct {
 
 // CiliumClusterMeshEnableResourceModel describes the resource data model.
 type CiliumClusterMeshEnableResourceModel struct {
-	EnableKVStoreMesh types.Bool   `tfsdk:"enable_kv_store_mesh"`
-	ServiceType       types.String `tfsdk:"service_type"`
-	Wait              types.Bool   `tfsdk:"wait"`
-	Id                types.String `tfsdk:"id"`
+	EnableKVStoreMesh types.Bool     `tfsdk:"enable_kv_store_mesh"`
+	ServiceType       types.String   `tfsdk:"service_type"`
+	Wait              types.Bool     `tfsdk:"wait"`
+	Namespace         types.String   `tfsdk:"namespace"`
+	HelmRelease       types.String   `tfsdk:"helm_release"`
+	Id                types.String   `tfsdk:"id"`
+	Timeouts          timeouts.Value `tfsdk:"timeouts"`
 }
 
 func (r *CiliumClusterMeshEnableResource) Metadata(ctx context.Context, req resource.MetadataRequest, resp *resource.MetadataResponse) {
@@ -48,11 +56,14 @@ func (r *CiliumClusterMeshEnableResource) Metadata(ctx context.Context, req reso
 }
 
 func (r *CiliumClusterMeshEnableResource) Schema(ctx context.Context, req resource.SchemaRequest, resp *resource.SchemaResponse) {
+	namespace, helmRelease := releaseAttributes(func() *CiliumClient { return r.client })
 	resp.Schema = schema.Schema{
 		// This description is used by the documentation generator and the language server.
 		MarkdownDescription: "Cluster Mesh resource. This is equivalent to cilium cli: `cilium clustermesh enable` and `cilium clustermesh disable`: It manages the activation of Cluster Mesh on one Kubernetes cluster.",
 
 		Attributes: map[string]schema.Attribute{
+			"namespace":    namespace,
+			"helm_release": helmRelease,
 			"enable_kv_store_mesh": schema.BoolAttribute{
 				MarkdownDescription: ConcatDefault("Enable kvstoremesh, an extension which caches remote cluster information in the local kvstore (Cilium >=1.14 only)", "false"),
 				Optional:            true,
@@ -79,6 +90,14 @@ func (r *CiliumClusterMeshEnableResource) Schema(ctx context.Context, req resour
 				},
 			},
 		},
+		Blocks: map[string]schema.Block{
+			"timeouts": timeouts.Block(ctx, timeouts.Opts{
+				Create: true,
+				Read:   true,
+				Update: true,
+				Delete: true,
+			}),
+		},
 	}
 }
 
@@ -104,37 +123,55 @@ func (r *CiliumClusterMeshEnableResource) Configure(ctx context.Context, req res
 
 func (r *CiliumClusterMeshEnableResource) Create(ctx context.Context, req resource.CreateRequest, resp *resource.CreateResponse) {
 	var data CiliumClusterMeshEnableResourceModel
-	c := r.client
+
+	// Read Terraform plan data into the model
+	resp.Diagnostics.Append(req.Plan.Get(ctx, &data)...)
+
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	c := r.client.ForRelease(data.Namespace, data.HelmRelease)
 	if c == nil {
 		resp.Diagnostics.AddError("Client Error", "Unable to connect to kubernetes")
 		return
 	}
-	k8sClient, namespace, helm_release := c.client, c.namespace, c.helm_release
+	k8sClient, err := c.K8sClient()
+	if err != nil {
+		resp.Diagnostics.AddError("Client Error", fmt.Sprintf("Unable to connect to kubernetes: %s", err))
+		return
+	}
+	namespace, helm_release := c.namespace, c.helm_release
 	var params = clustermesh.Parameters{
-		Writer: os.Stdout,
+		Writer: c.LogWriter(ctx, "cilium_clustermesh"),
 	}
 
-	// Read Terraform plan data into the model
-	resp.Diagnostics.Append(req.Plan.Get(ctx, &data)...)
-
+	createTimeout, diags := data.Timeouts.Create(ctx, 2*time.Minute)
+	resp.Diagnostics.Append(diags...)
 	if resp.Diagnostics.HasError() {
 		return
 	}
+	ctx, cancel := context.WithTimeout(ctx, createTimeout)
+	defer cancel()
+
 	params.Namespace = namespace
 	params.ServiceType = data.ServiceType.ValueString()
 	params.EnableKVStoreMesh = data.EnableKVStoreMesh.ValueBool() //
 	params.HelmReleaseName = helm_release
+	params.ImpersonateAs = c.impersonate_as
+	params.ImpersonateGroups = c.impersonate_groups
 	wait := data.Wait.ValueBool()
 
-	ctxb := context.Background()
-	if err := clustermesh.EnableWithHelm(ctxb, k8sClient, params); err != nil {
-		resp.Diagnostics.AddError("Client Error", fmt.Sprintf("Unable to enable ClusterMesh: %s", err))
+	if err := c.HelmMutation(ctx, func() error {
+		return clustermeshEnableWithHelm(ctx, k8sClient, params)
+	}); err != nil {
+		resp.Diagnostics.AddError("Client Error", fmt.Sprintf("Unable to enable ClusterMesh: %s", interrupted(ctx, err)))
 		return
 	}
 
 	if wait {
-		if err := c.WaitClusterMesh(); err != nil {
-			resp.Diagnostics.AddError("Client Error", fmt.Sprintf("Unable to enable ClusterMesh: %s", err))
+		if err := c.WaitClusterMesh(ctx, createTimeout); err != nil {
+			resp.Diagnostics.AddError("Client Error", fmt.Sprintf("Unable to enable ClusterMesh: %s", interrupted(ctx, err)))
 			return
 		}
 	}
@@ -153,72 +190,127 @@ func (r *CiliumClusterMeshEnableResource) Create(ctx context.Context, req resour
 
 func (r *CiliumClusterMeshEnableResource) Read(ctx context.Context, req resource.ReadRequest, resp *resource.ReadResponse) {
 	var data CiliumClusterMeshEnableResourceModel
-	var params = clustermesh.Parameters{
-		Writer: os.Stdout,
+
+	// Read Terraform prior state data into the model
+	resp.Diagnostics.Append(req.State.Get(ctx, &data)...)
+
+	if resp.Diagnostics.HasError() {
+		return
 	}
-	c := r.client
+
+	c := r.client.ForRelease(data.Namespace, data.HelmRelease)
 	if c == nil {
 		resp.Diagnostics.AddError("Client Error", "Unable to connect to kubernetes")
 		return
 	}
-	k8sClient, namespace, helm_release := c.client, c.namespace, c.helm_release
-
-	// Read Terraform prior state data into the model
-	resp.Diagnostics.Append(req.State.Get(ctx, &data)...)
+	var params = clustermesh.Parameters{
+		Writer: c.LogWriter(ctx, "cilium_clustermesh"),
+	}
+	k8sClient, err := c.K8sClient()
+	if errors.Is(err, errProviderConfigUnknown) {
+		// Keep the prior state until the provider configuration is known.
+		return
+	}
+	if err != nil {
+		resp.Diagnostics.AddError("Client Error", fmt.Sprintf("Unable to connect to kubernetes: %s", err))
+		return
+	}
+	namespace, helm_release := c.namespace, c.helm_release
 
+	readTimeout, diags := data.Timeouts.Read(ctx, 20*time.Second)
+	resp.Diagnostics.Append(diags...)
 	if resp.Diagnostics.HasError() {
 		return
 	}
+	ctx, cancel := context.WithTimeout(ctx, readTimeout)
+	defer cancel()
 
 	params.Namespace = namespace
 	params.Wait = true
-	params.WaitDuration = 20 * time.Second
+	params.WaitDuration = readTimeout
 	params.HelmReleaseName = helm_release
+	params.ImpersonateAs = c.impersonate_as
+	params.ImpersonateGroups = c.impersonate_groups
 
-	cm := clustermesh.NewK8sClusterMesh(k8sClient, params)
-	if _, err := cm.Status(context.Background()); err != nil {
-		fmt.Printf("Unable to determine status: %s\n", err)
+	if _, err := c.GetReleaseSnapshot(); isNotFound(err) {
+		resp.State.RemoveResource(ctx)
+		return
+	} else if err != nil {
+		resp.Diagnostics.AddError("Client Error", fmt.Sprintf("Unable to read Cilium release: %s", interrupted(ctx, err)))
+		return
+	}
+	enabled, err := c.ClusterMeshEnabled(ctx)
+	if err != nil {
+		resp.Diagnostics.AddError("Client Error", fmt.Sprintf("Unable to read ClusterMesh: %s", interrupted(ctx, err)))
+		return
+	}
+	if !enabled {
 		resp.State.RemoveResource(ctx)
 		return
 	}
 
+	// Cluster Mesh is enabled: an unhealthy status must not plan to enable it
+	// again.
+	cm := clustermesh.NewK8sClusterMesh(k8sClient, params)
+	if _, err := cm.Status(ctx); err != nil {
+		resp.Diagnostics.AddWarning("ClusterMesh Status", fmt.Sprintf("Unable to determine status: %s", interrupted(ctx, err)))
+	}
+
 	// Save updated data into Terraform state
 	resp.Diagnostics.Append(resp.State.Set(ctx, &data)...)
 }
 
 func (r *CiliumClusterMeshEnableResource) Update(ctx context.Context, req resource.UpdateRequest, resp *resource.UpdateResponse) {
 	var data CiliumClusterMeshEnableResourceModel
-	c := r.client
+
+	// Read Terraform plan data into the model
+	resp.Diagnostics.Append(req.Plan.Get(ctx, &data)...)
+
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	c := r.client.ForRelease(data.Namespace, data.HelmRelease)
 	if c == nil {
 		resp.Diagnostics.AddError("Client Error", "Unable to connect to kubernetes")
 		return
 	}
-	k8sClient, namespace, helm_release := c.client, c.namespace, c.helm_release
+	k8sClient, err := c.K8sClient()
+	if err != nil {
+		resp.Diagnostics.AddError("Client Error", fmt.Sprintf("Unable to connect to kubernetes: %s", err))
+		return
+	}
+	namespace, helm_release := c.namespace, c.helm_release
 	var params = clustermesh.Parameters{
-		Writer: os.Stdout,
+		Writer: c.LogWriter(ctx, "cilium_clustermesh"),
 	}
 
-	// Read Terraform plan data into the model
-	resp.Diagnostics.Append(req.Plan.Get(ctx, &data)...)
-
+	updateTimeout, diags := data.Timeouts.Update(ctx, 2*time.Minute)
+	resp.Diagnostics.Append(diags...)
 	if resp.Diagnostics.HasError() {
 		return
 	}
+	ctx, cancel := context.WithTimeout(ctx, updateTimeout)
+	defer cancel()
+
 	params.Namespace = namespace
 	params.ServiceType = data.ServiceType.ValueString()
 	params.EnableKVStoreMesh = data.EnableKVStoreMesh.ValueBool() //
 	params.HelmReleaseName = helm_release
+	params.ImpersonateAs = c.impersonate_as
+	params.ImpersonateGroups = c.impersonate_groups
 	wait := data.Wait.ValueBool()
 
-	ctxb := context.Background()
-	if err := clustermesh.EnableWithHelm(ctxb, k8sClient, params); err != nil {
-		resp.Diagnostics.AddError("Client Error", fmt.Sprintf("Unable to enable ClusterMesh: %s", err))
+	if err := c.HelmMutation(ctx, func() error {
+		return clustermeshEnableWithHelm(ctx, k8sClient, params)
+	}); err != nil {
+		resp.Diagnostics.AddError("Client Error", fmt.Sprintf("Unable to enable ClusterMesh: %s", interrupted(ctx, err)))
 		return
 	}
 
 	if wait {
-		if err := c.WaitClusterMesh(); err != nil {
-			resp.Diagnostics.AddError("Client Error", fmt.Sprintf("Unable to enable ClusterMesh: %s", err))
+		if err := c.WaitClusterMesh(ctx, updateTimeout); err != nil {
+			resp.Diagnostics.AddError("Client Error", fmt.Sprintf("Unable to enable ClusterMesh: %s", interrupted(ctx, err)))
 			return
 		}
 	}
@@ -229,29 +321,46 @@ func (r *CiliumClusterMeshEnableResource) Update(ctx context.Context, req resour
 
 func (r *CiliumClusterMeshEnableResource) Delete(ctx context.Context, req resource.DeleteRequest, resp *resource.DeleteResponse) {
 	var data CiliumClusterMeshEnableResourceModel
-	c := r.client
+
+	//// Read Terraform prior state data into the model
+	resp.Diagnostics.Append(req.State.Get(ctx, &data)...)
+
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	c := r.client.ForRelease(data.Namespace, data.HelmRelease)
 	if c == nil {
 		resp.Diagnostics.AddError("Client Error", "Unable to connect to kubernetes")
 		return
 	}
-	k8sClient, namespace, helm_release := c.client, c.namespace, c.helm_release
+	k8sClient, err := c.K8sClient()
+	if err != nil {
+		resp.Diagnostics.AddError("Client Error", fmt.Sprintf("Unable to connect to kubernetes: %s", err))
+		return
+	}
+	namespace, helm_release := c.namespace, c.helm_release
 	var params = clustermesh.Parameters{
-		Writer: os.Stdout,
+		Writer: c.LogWriter(ctx, "cilium_clustermesh"),
 	}
 
-	//// Read Terraform prior state data into the model
-	resp.Diagnostics.Append(req.State.Get(ctx, &data)...)
-
+	deleteTimeout, diags := data.Timeouts.Delete(ctx, defaults.StatusWaitDuration)
+	resp.Diagnostics.Append(diags...)
 	if resp.Diagnostics.HasError() {
 		return
 	}
+	ctx, cancel := context.WithTimeout(ctx, deleteTimeout)
+	defer cancel()
 
 	params.Namespace = namespace
 	params.HelmReleaseName = helm_release
-	ctxb := context.Background()
+	params.ImpersonateAs = c.impersonate_as
+	params.ImpersonateGroups = c.impersonate_groups
 
-	if err := clustermesh.DisableWithHelm(ctxb, k8sClient, params); err != nil {
-		resp.Diagnostics.AddError("Client Error", fmt.Sprintf("Unable to disable ClusterMesh: %s", err))
+	if err := c.HelmMutation(ctx, func() error {
+		return clustermesh.DisableWithHelm(ctx, k8sClient, params)
+	}); err != nil {
+		resp.Diagnostics.AddError("Client Error", fmt.Sprintf("Unable to disable ClusterMesh: %s", interrupted(ctx, err)))
 		return
 	}
 }
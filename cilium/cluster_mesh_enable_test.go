@@ -4,9 +4,16 @@
 package provider
 
 import (
+	"context"
 	"fmt"
+	"net/http"
 	"testing"
 
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	fwresource "github.com/hashicorp/terraform-plugin-framework/resource"
+	"github.com/hashicorp/terraform-plugin-go/tftypes"
 	"github.com/hashicorp/terraform-plugin-testing/helper/resource"
 )
 
@@ -47,3 +54,58 @@ resource "cilium_clustermesh" "test" {
 }
 `, service_type)
 }
+
+func TestCiliumClusterMeshEnableResourceRead(t *testing.T) {
+	deployment := "/apis/apps/v1/namespaces/kube-system/deployments/clustermesh-apiserver"
+	for name, tc := range map[string]struct {
+		routes  map[string]http.HandlerFunc
+		removed bool
+		errors  int
+		warns   int
+	}{
+		"disabled": {
+			removed: true,
+		},
+		"transient error": {
+			routes: map[string]http.HandlerFunc{
+				deployment: testStatusError(http.StatusServiceUnavailable, "etcdserver: request timed out"),
+			},
+			errors: 1,
+		},
+		"unhealthy": {
+			routes: map[string]http.HandlerFunc{
+				deployment: testObject(appsv1.Deployment{
+					TypeMeta:   metav1.TypeMeta{APIVersion: "apps/v1", Kind: "Deployment"},
+					ObjectMeta: metav1.ObjectMeta{Name: "clustermesh-apiserver", Namespace: "kube-system"},
+				}),
+			},
+			warns: 1,
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			srv := testFakeCluster(t, "kind-test", tc.routes)
+			r := NewCiliumClusterMeshEnableResource()
+			c := testResourceClient(t, r, srv, map[string]tftypes.Value{
+				"helm_driver": tftypes.NewValue(tftypes.String, "memory"),
+			})
+			testCreateRelease(t, c, map[string]interface{}{})
+
+			state := testResourceState(t, r, map[string]tftypes.Value{
+				"id":       tftypes.NewValue(tftypes.String, "ciliumclustermeshenable"),
+				"timeouts": testTimeouts("1s"),
+			})
+			resp := &fwresource.ReadResponse{State: state}
+			r.Read(context.Background(), fwresource.ReadRequest{State: state}, resp)
+
+			if got := resp.Diagnostics.ErrorsCount(); got != tc.errors {
+				t.Errorf("got %d errors: %v", got, resp.Diagnostics)
+			}
+			if got := resp.Diagnostics.WarningsCount(); got != tc.warns {
+				t.Errorf("got %d warnings: %v", got, resp.Diagnostics)
+			}
+			if removed := resp.State.Raw.IsNull(); removed != tc.removed {
+				t.Errorf("removed: got %t, expected %t", removed, tc.removed)
+			}
+		})
+	}
+}
@@ -0,0 +1,557 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package provider
+
+import (
+	"bytes"
+	"context"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/cilium/cilium/cilium-cli/clustermesh"
+	"github.com/cilium/cilium/cilium-cli/defaults"
+	"github.com/cilium/cilium/cilium-cli/k8s"
+	"helm.sh/helm/v3/pkg/action"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// The Cluster Mesh connections follow `cilium clustermesh connect` and
+// `cilium clustermesh disconnect` in Helm mode. cilium-cli builds the clients
+// of the remote clusters from the default kubeconfig (KUBECONFIG) only: the
+// provider builds them from its own kubeconfig instead, in memory.
+
+// clustermeshHelmUpgrade is replaced in tests.
+var clustermeshHelmUpgrade = func(ctx context.Context, client *k8s.Client, params clustermesh.Parameters, values map[string]interface{}) error {
+	last, err := client.HelmActionConfig.Releases.Last(params.HelmReleaseName)
+	if err != nil {
+		return err
+	}
+	upgrade := action.NewUpgrade(client.HelmActionConfig)
+	upgrade.Namespace = params.Namespace
+	upgrade.ReuseValues = true
+	_, err = upgrade.RunWithContext(ctx, params.HelmReleaseName, last.Chart, values)
+	return err
+}
+
+// clusterMeshAccess is the access information of the clustermesh-apiserver of
+// a cluster.
+type clusterMeshAccess struct {
+	name                 string
+	id                   string
+	maxConnectedClusters int
+	ips                  []string
+	port                 int
+	ca                   []byte
+	clientCert           []byte
+	clientKey            []byte
+}
+
+// clusterMeshIdentity returns the name and the ID of the cluster from the
+// Cilium configuration.
+func clusterMeshIdentity(ctx context.Context, client *k8s.Client, namespace string) (*clusterMeshAccess, error) {
+	cm, err := client.GetConfigMap(ctx, namespace, defaults.ConfigMapName, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("unable to retrieve ConfigMap %q: %w", defaults.ConfigMapName, err)
+	}
+	name, ok := cm.Data["cluster-name"]
+	if !ok {
+		return nil, fmt.Errorf("cluster-name is not set in ConfigMap %q", defaults.ConfigMapName)
+	}
+	ai := &clusterMeshAccess{
+		name:                 name,
+		id:                   cm.Data["cluster-id"],
+		maxConnectedClusters: defaults.ClustermeshMaxConnectedClusters,
+	}
+	if mcc, ok := cm.Data["max-connected-clusters"]; ok {
+		if ai.maxConnectedClusters, err = strconv.Atoi(mcc); err != nil {
+			return nil, fmt.Errorf("unable to parse max-connected-clusters: %w", err)
+		}
+	}
+	return ai, nil
+}
+
+// clusterMeshAccessInformation extracts the access information of the
+// clustermesh-apiserver of a cluster, like cilium-cli.
+func clusterMeshAccessInformation(ctx context.Context, client *k8s.Client, params clustermesh.Parameters) (*clusterMeshAccess, error) {
+	ai, err := clusterMeshIdentity(ctx, client, params.Namespace)
+	if err != nil {
+		return nil, err
+	}
+	var ok bool
+	svc, err := client.GetService(ctx, params.Namespace, defaults.ClusterMeshServiceName, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("unable to get clustermesh service %q: %w", defaults.ClusterMeshServiceName, err)
+	}
+
+	// Helm and older versions of cilium-cli named the secret differently.
+	var secret *corev1.Secret
+	for _, name := range []string{defaults.ClusterMeshRemoteSecretName, defaults.ClusterMeshClientSecretName, defaults.ClusterMeshClientSecretName + "s"} {
+		if secret, err = client.GetSecret(ctx, params.Namespace, name, metav1.GetOptions{}); err == nil {
+			break
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to get client secret to access clustermesh service: %w", err)
+	}
+	if ai.clientKey, ok = secret.Data[corev1.TLSPrivateKeyKey]; !ok {
+		return nil, fmt.Errorf("secret %q does not contain key %q", secret.Name, corev1.TLSPrivateKeyKey)
+	}
+	if ai.clientCert, ok = secret.Data[corev1.TLSCertKey]; !ok {
+		return nil, fmt.Errorf("secret %q does not contain key %q", secret.Name, corev1.TLSCertKey)
+	}
+	// cert-manager stores the CA under tls.crt, the other methods under ca.crt.
+	if ca, err := client.GetSecret(ctx, params.Namespace, defaults.CASecretName, metav1.GetOptions{}); err == nil {
+		ai.ca = ca.Data[defaults.CASecretCertName]
+		if ai.ca == nil {
+			ai.ca = ca.Data[corev1.TLSCertKey]
+		}
+	}
+	if ai.ca == nil {
+		if ai.ca, ok = secret.Data[defaults.CASecretCertName]; !ok {
+			return nil, fmt.Errorf("unable to retrieve the CA certificate of cluster %s", ai.name)
+		}
+	}
+
+	switch svc.Spec.Type {
+	case corev1.ServiceTypeClusterIP:
+		if len(svc.Spec.Ports) == 0 || svc.Spec.Ports[0].Port == 0 {
+			return nil, fmt.Errorf("port of service could not be derived")
+		}
+		ai.port = int(svc.Spec.Ports[0].Port)
+		if svc.Spec.ClusterIP != "" {
+			ai.ips = append(ai.ips, svc.Spec.ClusterIP)
+		}
+	case corev1.ServiceTypeNodePort:
+		if len(svc.Spec.Ports) == 0 || svc.Spec.Ports[0].NodePort == 0 {
+			return nil, fmt.Errorf("nodeport is not set in service")
+		}
+		ai.port = int(svc.Spec.Ports[0].NodePort)
+		nodes, err := client.ListNodes(ctx, metav1.ListOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("unable to list nodes in cluster: %w", err)
+		}
+		// The NodePort of a single node: the clusters are given one
+		// endpoint each.
+		for _, node := range nodes.Items {
+			if ip := nodeIP(node); ip != "" {
+				ai.ips = append(ai.ips, ip)
+				break
+			}
+		}
+	case corev1.ServiceTypeLoadBalancer:
+		if len(svc.Spec.Ports) == 0 {
+			return nil, fmt.Errorf("port of service could not be derived, service has no ports")
+		}
+		ai.port = int(svc.Spec.Ports[0].Port)
+		for _, ingress := range svc.Status.LoadBalancer.Ingress {
+			if ingress.Hostname == "" {
+				ai.ips = append(ai.ips, ingress.IP)
+			} else if ips, err := net.LookupHost(ingress.Hostname); err == nil {
+				ai.ips = append(ai.ips, ips...)
+			} else {
+				ai.ips = append(ai.ips, ingress.IP)
+			}
+		}
+	}
+	if len(ai.ips) == 0 {
+		return nil, fmt.Errorf("unable to derive service IPs of cluster %s automatically", ai.name)
+	}
+	return ai, nil
+}
+
+// nodeIP returns the external IP of a node, else its internal IP.
+func nodeIP(node corev1.Node) string {
+	ip := ""
+	for _, address := range node.Status.Addresses {
+		switch address.Type {
+		case corev1.NodeExternalIP:
+			return address.Address
+		case corev1.NodeInternalIP:
+			if ip == "" {
+				ip = address.Address
+			}
+		}
+	}
+	return ip
+}
+
+// validateConnection checks that the clusters can be connected: they need
+// distinct names and IDs in the range of the local cluster.
+func validateConnection(local, remote *clusterMeshAccess) error {
+	valid := func(ai *clusterMeshAccess) bool {
+		return ai.name != "" && ai.name != "default" && ai.id != "" && ai.id != "0"
+	}
+	if !valid(remote) {
+		return fmt.Errorf("remote cluster has non-unique name (%s) and/or ID (%s)", remote.name, remote.id)
+	}
+	if !valid(local) {
+		return fmt.Errorf("local cluster has the default name (cluster name: %s) and/or ID 0 (cluster ID: %s)", local.name, local.id)
+	}
+	id, err := strconv.Atoi(remote.id)
+	if err != nil {
+		return fmt.Errorf("remote cluster has non-numeric cluster ID %s", remote.id)
+	}
+	if id < 1 || id > local.maxConnectedClusters {
+		return fmt.Errorf("remote cluster has cluster ID %d out of acceptable range (1-%d)", id, local.maxConnectedClusters)
+	}
+	if remote.maxConnectedClusters != local.maxConnectedClusters {
+		return fmt.Errorf("remote and local clusters have different max connected clusters: %d != %d", remote.maxConnectedClusters, local.maxConnectedClusters)
+	}
+	if remote.name == local.name {
+		return fmt.Errorf("remote and local cluster have the same, non-unique name: %s", local.name)
+	}
+	if remote.id == local.id {
+		return fmt.Errorf("remote and local cluster have the same, non-unique ID: %s", local.id)
+	}
+	return nil
+}
+
+// sameCA tells whether the CA certificates of the clusters have the same key.
+func sameCA(local, remote *clusterMeshAccess) (bool, error) {
+	keyID := func(ai *clusterMeshAccess) ([]byte, error) {
+		block, _ := pem.Decode(ai.ca)
+		if block == nil {
+			return nil, fmt.Errorf("failed to parse certificate PEM of the CA of cluster %s", ai.name)
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		return cert.SubjectKeyId, nil
+	}
+	localKey, err := keyID(local)
+	if err != nil {
+		return false, err
+	}
+	remoteKey, err := keyID(remote)
+	if err != nil {
+		return false, err
+	}
+	return bytes.Equal(localKey, remoteKey), nil
+}
+
+// clusterMeshCluster returns the entry of clustermesh.config.clusters for a
+// cluster. The client certificates are only given when the CAs differ.
+func clusterMeshCluster(ai *clusterMeshAccess, configTLS bool) map[string]interface{} {
+	cluster := map[string]interface{}{
+		"name": ai.name,
+		"ips":  []string{ai.ips[0]},
+		"port": ai.port,
+	}
+	if configTLS {
+		cluster["tls"] = map[string]interface{}{
+			"cert":   base64.StdEncoding.EncodeToString(ai.clientCert),
+			"key":    base64.StdEncoding.EncodeToString(ai.clientKey),
+			"caCert": base64.StdEncoding.EncodeToString(ai.ca),
+		}
+	}
+	return cluster
+}
+
+// clusterMeshClusters returns the entries of clustermesh.config.clusters of
+// the values of a release.
+func clusterMeshClusters(values map[string]interface{}) ([]map[string]interface{}, error) {
+	v, _ := GetValue(values, "clustermesh.config.clusters")
+	if v == nil {
+		return nil, nil
+	}
+	list, ok := v.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("existing clustermesh.config.clusters array is invalid")
+	}
+	clusters := make([]map[string]interface{}, 0, len(list))
+	for _, item := range list {
+		cluster, ok := item.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("existing clustermesh.config.clusters array is invalid")
+		}
+		clusters = append(clusters, cluster)
+	}
+	return clusters, nil
+}
+
+// clusterMeshValues returns the values which set clustermesh.config.clusters
+// to the given entries, sorted by name.
+func clusterMeshValues(clusters map[string]map[string]interface{}) map[string]interface{} {
+	list := make([]interface{}, 0, len(clusters))
+	for _, name := range sortedKeys(clusters) {
+		list = append(list, clusters[name])
+	}
+	return map[string]interface{}{
+		"clustermesh": map[string]interface{}{
+			"config": map[string]interface{}{
+				"enabled":  true,
+				"clusters": list,
+			},
+		},
+	}
+}
+
+// mergeClusterMeshClusters adds the new entries to the old ones, replacing the
+// ones of the same name, except the one of the cluster itself.
+func mergeClusterMeshClusters(old, added []map[string]interface{}, except string) (map[string]interface{}, error) {
+	clusters := map[string]map[string]interface{}{}
+	for _, c := range old {
+		name, ok := c["name"].(string)
+		if !ok {
+			return nil, fmt.Errorf("existing clustermesh.config.clusters array is invalid")
+		}
+		clusters[name] = c
+	}
+	for _, c := range added {
+		if name := c["name"].(string); name != except {
+			clusters[name] = c
+		}
+	}
+	return clusterMeshValues(clusters), nil
+}
+
+// removeClusterMeshClusters removes the entries of the given clusters from
+// the values of a release.
+func removeClusterMeshClusters(values map[string]interface{}, names []string) (map[string]interface{}, error) {
+	old, err := clusterMeshClusters(values)
+	if err != nil {
+		return nil, err
+	}
+	clusters := map[string]map[string]interface{}{}
+	for _, c := range old {
+		name, _ := c["name"].(string)
+		if !slices.Contains(names, name) {
+			clusters[name] = c
+		}
+	}
+	return clusterMeshValues(clusters), nil
+}
+
+// RemoteK8sClients returns the clients of the destination contexts of Cluster
+// Mesh, from the kubeconfig of the provider. They are shared by the release
+// clients of the provider.
+func (c *CiliumClient) RemoteK8sClients(contexts []string) ([]*k8s.Client, error) {
+	root := c
+	if c.provider != nil {
+		root = c.provider
+	}
+	root.mutex.Lock()
+	defer root.mutex.Unlock()
+	clients := make([]*k8s.Client, 0, len(contexts))
+	for _, contextName := range contexts {
+		key := contextName + "/" + c.helmNamespace()
+		client, ok := root.remotes[key]
+		if !ok {
+			if root.connectContext == nil {
+				return nil, fmt.Errorf("unable to create Kubernetes client to access remote cluster %q: the provider has no kube config", contextName)
+			}
+			var err error
+			if client, err = root.connectContext(contextName, c.helmNamespace()); err != nil {
+				return nil, fmt.Errorf("unable to create Kubernetes client to access remote cluster %q: %w", contextName, err)
+			}
+			if root.remotes == nil {
+				root.remotes = map[string]*k8s.Client{}
+			}
+			root.remotes[key] = client
+		}
+		clients = append(clients, client)
+	}
+	return clients, nil
+}
+
+// checkConnectionMode rejects the connection modes unknown to cilium-cli.
+func checkConnectionMode(mode string) error {
+	modes := []string{defaults.ClusterMeshConnectionModeBidirectional, defaults.ClusterMeshConnectionModeMesh, defaults.ClusterMeshConnectionModeUnicast}
+	if !slices.Contains(modes, mode) {
+		return fmt.Errorf("bad connection mode %q, available connection modes: %s", mode, strings.Join(modes, ", "))
+	}
+	return nil
+}
+
+// ConnectClusterMesh connects the cluster of the release to the clusters of
+// the destination contexts, like `cilium clustermesh connect` in Helm mode.
+func (c *CiliumClient) ConnectClusterMesh(ctx context.Context, params clustermesh.Parameters) error {
+	if params.Writer == nil {
+		params.Writer = io.Discard
+	}
+	if err := checkConnectionMode(params.ConnectionMode); err != nil {
+		return err
+	}
+	localRelease, err := c.client.HelmActionConfig.Releases.Last(params.HelmReleaseName)
+	if err != nil {
+		return fmt.Errorf("unable to find Helm release for the target cluster: %w", err)
+	}
+	remoteClients, err := c.RemoteK8sClients(params.DestinationContext)
+	if err != nil {
+		return err
+	}
+	localOld, err := clusterMeshClusters(localRelease.Config)
+	if err != nil {
+		return err
+	}
+	local, err := clusterMeshAccessInformation(ctx, c.client, params)
+	if err != nil {
+		return fmt.Errorf("unable to retrieve access information of cluster %q: %w", c.client.ClusterName(), err)
+	}
+
+	var localNew []map[string]interface{}
+	var localCluster map[string]interface{}
+	remoteOld := map[*k8s.Client][]map[string]interface{}{}
+	remoteNames := map[*k8s.Client]string{}
+	for _, remoteClient := range remoteClients {
+		remote, err := clusterMeshAccessInformation(ctx, remoteClient, params)
+		if err != nil {
+			return fmt.Errorf("unable to retrieve access information of cluster %q: %w", remoteClient.ClusterName(), err)
+		}
+		if err := validateConnection(local, remote); err != nil {
+			return err
+		}
+		match, err := sameCA(local, remote)
+		if err != nil {
+			return err
+		}
+		if !match {
+			fmt.Fprintf(params.Writer, "⚠️ Cilium CA certificates do not match between clusters %s and %s. Multicluster features will be limited!\n", local.name, remote.name)
+		}
+		localNew = append(localNew, clusterMeshCluster(remote, !match))
+		localCluster = clusterMeshCluster(local, !match)
+
+		remoteRelease, err := remoteClient.HelmActionConfig.Releases.Last(params.HelmReleaseName)
+		if err != nil {
+			return fmt.Errorf("unable to find Helm release for the remote cluster %s: %w", remote.name, err)
+		}
+		if remoteOld[remoteClient], err = clusterMeshClusters(remoteRelease.Config); err != nil {
+			return err
+		}
+		remoteNames[remoteClient] = remote.name
+	}
+
+	localValues, err := mergeClusterMeshClusters(localOld, localNew, "")
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(params.Writer, "ℹ️ Configuring Cilium in cluster %s to connect to clusters %s\n", local.name, strings.Join(sortedValues(remoteNames), ","))
+	if err := clustermeshHelmUpgrade(ctx, c.client, params, localValues); err != nil {
+		return err
+	}
+
+	if params.ConnectionMode == defaults.ClusterMeshConnectionModeUnicast {
+		return nil
+	}
+	// In bidirectional mode the remote clusters connect to the local one, in
+	// mesh mode to all the others.
+	added := []map[string]interface{}{localCluster}
+	if params.ConnectionMode == defaults.ClusterMeshConnectionModeMesh {
+		added = append(added, localNew...)
+	}
+	parallel := max(params.Parallel, 1)
+	sem := make(chan struct{}, parallel)
+	var wg sync.WaitGroup
+	var mutex sync.Mutex
+	var firstErr error
+	for _, remoteClient := range remoteClients {
+		values, err := mergeClusterMeshClusters(remoteOld[remoteClient], added, remoteNames[remoteClient])
+		if err != nil {
+			return err
+		}
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(remoteClient *k8s.Client, values map[string]interface{}) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			fmt.Fprintf(params.Writer, "ℹ️ Configuring Cilium in cluster %s to connect to cluster %s\n", remoteNames[remoteClient], local.name)
+			if err := clustermeshHelmUpgrade(ctx, remoteClient, params, values); err != nil {
+				mutex.Lock()
+				if firstErr == nil {
+					firstErr = err
+				}
+				mutex.Unlock()
+			}
+		}(remoteClient, values)
+	}
+	wg.Wait()
+	return firstErr
+}
+
+// DisconnectClusterMesh disconnects the cluster of the release from the
+// clusters of the destination contexts, like `cilium clustermesh disconnect`
+// in Helm mode.
+func (c *CiliumClient) DisconnectClusterMesh(ctx context.Context, params clustermesh.Parameters) error {
+	if params.Writer == nil {
+		params.Writer = io.Discard
+	}
+	if len(params.DestinationContext) == 0 {
+		return fmt.Errorf("no destination context specified")
+	}
+	if err := checkConnectionMode(params.ConnectionMode); err != nil {
+		return err
+	}
+	localRelease, err := c.client.HelmActionConfig.Releases.Last(params.HelmReleaseName)
+	if err != nil {
+		return fmt.Errorf("unable to find Helm release for the target cluster: %w", err)
+	}
+	remoteClients, err := c.RemoteK8sClients(params.DestinationContext)
+	if err != nil {
+		return err
+	}
+	remoteNames := make([]string, 0, len(remoteClients))
+	for _, remoteClient := range remoteClients {
+		remote, err := clusterMeshIdentity(ctx, remoteClient, params.Namespace)
+		if err != nil {
+			return err
+		}
+		remoteNames = append(remoteNames, remote.name)
+	}
+	local, err := clusterMeshIdentity(ctx, c.client, params.Namespace)
+	if err != nil {
+		return err
+	}
+
+	localValues, err := removeClusterMeshClusters(localRelease.Config, remoteNames)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(params.Writer, "ℹ️ Configuring Cilium in cluster %s to disconnect from clusters %s\n", local.name, strings.Join(remoteNames, ","))
+	if err := clustermeshHelmUpgrade(ctx, c.client, params, localValues); err != nil {
+		return err
+	}
+
+	if params.ConnectionMode == defaults.ClusterMeshConnectionModeUnicast {
+		return nil
+	}
+	removed := []string{local.name}
+	if params.ConnectionMode == defaults.ClusterMeshConnectionModeMesh {
+		removed = append(removed, remoteNames...)
+	}
+	for i, remoteClient := range remoteClients {
+		remoteRelease, err := remoteClient.HelmActionConfig.Releases.Last(params.HelmReleaseName)
+		if err != nil {
+			return fmt.Errorf("unable to find Helm release for the remote cluster %s: %w", remoteNames[i], err)
+		}
+		values, err := removeClusterMeshClusters(remoteRelease.Config, removed)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(params.Writer, "ℹ️ Configuring Cilium in cluster %s to disconnect from cluster %s\n", remoteNames[i], local.name)
+		if err := clustermeshHelmUpgrade(ctx, remoteClient, params, values); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sortedValues returns the values of a map, sorted.
+func sortedValues[K comparable](m map[K]string) []string {
+	values := make([]string, 0, len(m))
+	for _, v := range m {
+		values = append(values, v)
+	}
+	slices.Sort(values)
+	return values
+}
@@ -6,12 +6,14 @@ package provider
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"os"
 	"regexp"
 
 	"github.com/cilium/cilium/cilium-cli/config"
+	"github.com/cilium/cilium/cilium-cli/defaults"
 
+	"github.com/hashicorp/terraform-plugin-framework-timeouts/resource/timeouts"
 	"github.com/hashicorp/terraform-plugin-framework/path"
 	"github.com/hashicorp/terraform-plugin-framework/resource"
 	"github.com/hashicorp/terraform-plugin-framework/resource/schema"
@@ -37,10 +39,13 @@ type CiliumConfigResource struct {
 
 // CiliumConfigResourceModel describes the resource data model.
 type CiliumConfigResourceModel struct {
-	Restart types.Bool   `tfsdk:"restart"`
-	Key     types.String `tfsdk:"key"`
-	Value   types.String `tfsdk:"value"`
-	Id      types.String `tfsdk:"id"`
+	Restart     types.Bool     `tfsdk:"restart"`
+	Key         types.String   `tfsdk:"key"`
+	Value       types.String   `tfsdk:"value"`
+	Namespace   types.String   `tfsdk:"namespace"`
+	HelmRelease types.String   `tfsdk:"helm_release"`
+	Id          types.String   `tfsdk:"id"`
+	Timeouts    timeouts.Value `tfsdk:"timeouts"`
 }
 
 func (r *CiliumConfigResource) Metadata(ctx context.Context, req resource.MetadataRequest, resp *resource.MetadataResponse) {
@@ -48,11 +53,14 @@ func (r *CiliumConfigResource) Metadata(ctx context.Context, req resource.Metada
 }
 
 func (r *CiliumConfigResource) Schema(ctx context.Context, req resource.SchemaRequest, resp *resource.SchemaResponse) {
+	namespace, helmRelease := releaseAttributes(func() *CiliumClient { return r.client })
 	resp.Schema = schema.Schema{
 		// This description is used by the documentation generator and the language server.
 		MarkdownDescription: "Config resource for Cilium. This is equivalent to cilium cli: `cilium config`: It manages the cilium Kubernetes ConfigMap resource",
 
 		Attributes: map[string]schema.Attribute{
+			"namespace":    namespace,
+			"helm_release": helmRelease,
 			"restart": schema.BoolAttribute{
 				MarkdownDescription: ConcatDefault("Restart Cilium pods", "true"),
 				Optional:            true,
@@ -75,6 +83,14 @@ func (r *CiliumConfigResource) Schema(ctx context.Context, req resource.SchemaRe
 				},
 			},
 		},
+		Blocks: map[string]schema.Block{
+			"timeouts": timeouts.Block(ctx, timeouts.Opts{
+				Create: true,
+				Read:   true,
+				Update: true,
+				Delete: true,
+			}),
+		},
 	}
 }
 
@@ -100,30 +116,45 @@ func (r *CiliumConfigResource) Configure(ctx context.Context, req resource.Confi
 
 func (r *CiliumConfigResource) Create(ctx context.Context, req resource.CreateRequest, resp *resource.CreateResponse) {
 	var data CiliumConfigResourceModel
-	c := r.client
+
+	// Read Terraform plan data into the model
+	resp.Diagnostics.Append(req.Plan.Get(ctx, &data)...)
+
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	c := r.client.ForRelease(data.Namespace, data.HelmRelease)
 	if c == nil {
 		resp.Diagnostics.AddError("Client Error", "Unable to connect to kubernetes")
 		return
 	}
-	k8sClient, namespace := c.client, c.namespace
+	k8sClient, err := c.K8sClient()
+	if err != nil {
+		resp.Diagnostics.AddError("Client Error", fmt.Sprintf("Unable to connect to kubernetes: %s", err))
+		return
+	}
+	namespace := c.namespace
 	var params = config.Parameters{
-		Writer: os.Stdout,
+		Writer: c.LogWriter(ctx, "cilium_config"),
 	}
 
-	// Read Terraform plan data into the model
-	resp.Diagnostics.Append(req.Plan.Get(ctx, &data)...)
-
+	createTimeout, diags := data.Timeouts.Create(ctx, defaults.StatusWaitDuration)
+	resp.Diagnostics.Append(diags...)
 	if resp.Diagnostics.HasError() {
 		return
 	}
+	ctx, cancel := context.WithTimeout(ctx, createTimeout)
+	defer cancel()
+
 	key := data.Key.ValueString()
 	value := data.Value.ValueString()
 	params.Namespace = namespace
 	params.Restart = data.Restart.ValueBool()
 
 	check := config.NewK8sConfig(k8sClient, params)
-	if err := check.Set(context.Background(), key, value, params); err != nil {
-		resp.Diagnostics.AddError("Client Error", fmt.Sprintf("Unable to set config: %s", err))
+	if err := check.Set(ctx, key, value, params); err != nil {
+		resp.Diagnostics.AddError("Client Error", fmt.Sprintf("Unable to set config: %s", interrupted(ctx, err)))
 		return
 	}
 
@@ -141,22 +172,41 @@ func (r *CiliumConfigResource) Create(ctx context.Context, req resource.CreateRe
 
 func (r *CiliumConfigResource) Read(ctx context.Context, req resource.ReadRequest, resp *resource.ReadResponse) {
 	var data CiliumConfigResourceModel
-	c := r.client
+
+	// Read Terraform prior state data into the model
+	resp.Diagnostics.Append(req.State.Get(ctx, &data)...)
+
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	c := r.client.ForRelease(data.Namespace, data.HelmRelease)
 	if c == nil {
 		resp.Diagnostics.AddError("Client Error", "Unable to connect to kubernetes")
 		return
 	}
-	k8sClient, namespace := c.client, c.namespace
+	k8sClient, err := c.K8sClient()
+	if errors.Is(err, errProviderConfigUnknown) {
+		// Keep the prior state until the provider configuration is known.
+		return
+	}
+	if err != nil {
+		resp.Diagnostics.AddError("Client Error", fmt.Sprintf("Unable to connect to kubernetes: %s", err))
+		return
+	}
+	namespace := c.namespace
 	var params = config.Parameters{
-		Writer: os.Stdout,
+		Writer: c.LogWriter(ctx, "cilium_config"),
 	}
 
-	// Read Terraform prior state data into the model
-	resp.Diagnostics.Append(req.State.Get(ctx, &data)...)
-
+	readTimeout, diags := data.Timeouts.Read(ctx, defaults.StatusWaitDuration)
+	resp.Diagnostics.Append(diags...)
 	if resp.Diagnostics.HasError() {
 		return
 	}
+	ctx, cancel := context.WithTimeout(ctx, readTimeout)
+	defer cancel()
+
 	params.Namespace = namespace
 	key := data.Key.ValueString()
 	value := data.Value.ValueString()
@@ -168,7 +218,7 @@ func (r *CiliumConfigResource) Read(ctx context.Context, req resource.ReadReques
 		Restart: data.Restart,
 	}
 
-	_, err := json.Marshal(readReq)
+	_, err = json.Marshal(readReq)
 
 	if err != nil {
 		resp.Diagnostics.AddError(
@@ -182,19 +232,23 @@ func (r *CiliumConfigResource) Read(ctx context.Context, req resource.ReadReques
 	}
 
 	check := config.NewK8sConfig(k8sClient, params)
-	out, err := check.View(context.Background())
+	out, err := check.View(ctx)
+	if isNotFound(err) {
+		resp.State.RemoveResource(ctx)
+		return
+	}
 	if err != nil {
-		resp.Diagnostics.AddError("Client Error", fmt.Sprintf("Unable to view config: %s", err))
+		resp.Diagnostics.AddError("Client Error", fmt.Sprintf("Unable to view config: %s", interrupted(ctx, err)))
 		return
 	}
 
 	m, err := regexp.MatchString(key+".*"+value, out)
 	if err != nil {
-		fmt.Println("your regex is faulty")
+		tflog.Warn(ctx, "your regex is faulty")
 		return
 	}
 	if m {
-		fmt.Println("Ok")
+		tflog.Debug(ctx, "Ok")
 	} else {
 		resp.State.RemoveResource(ctx)
 		return
@@ -206,22 +260,36 @@ func (r *CiliumConfigResource) Read(ctx context.Context, req resource.ReadReques
 
 func (r *CiliumConfigResource) Update(ctx context.Context, req resource.UpdateRequest, resp *resource.UpdateResponse) {
 	var data CiliumConfigResourceModel
-	c := r.client
+
+	// Read Terraform plan data into the model
+	resp.Diagnostics.Append(req.Plan.Get(ctx, &data)...)
+
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	c := r.client.ForRelease(data.Namespace, data.HelmRelease)
 	if c == nil {
 		resp.Diagnostics.AddError("Client Error", "Unable to connect to kubernetes")
 		return
 	}
-	k8sClient, namespace := c.client, c.namespace
+	k8sClient, err := c.K8sClient()
+	if err != nil {
+		resp.Diagnostics.AddError("Client Error", fmt.Sprintf("Unable to connect to kubernetes: %s", err))
+		return
+	}
+	namespace := c.namespace
 	var params = config.Parameters{
-		Writer: os.Stdout,
+		Writer: c.LogWriter(ctx, "cilium_config"),
 	}
 
-	// Read Terraform plan data into the model
-	resp.Diagnostics.Append(req.Plan.Get(ctx, &data)...)
-
+	updateTimeout, diags := data.Timeouts.Update(ctx, defaults.StatusWaitDuration)
+	resp.Diagnostics.Append(diags...)
 	if resp.Diagnostics.HasError() {
 		return
 	}
+	ctx, cancel := context.WithTimeout(ctx, updateTimeout)
+	defer cancel()
 
 	key := data.Key.ValueString()
 	value := data.Value.ValueString()
@@ -229,8 +297,8 @@ func (r *CiliumConfigResource) Update(ctx context.Context, req resource.UpdateRe
 	params.Restart = data.Restart.ValueBool()
 
 	check := config.NewK8sConfig(k8sClient, params)
-	if err := check.Set(context.Background(), key, value, params); err != nil {
-		resp.Diagnostics.AddError("Client Error", fmt.Sprintf("Unable to set config: %s", err))
+	if err := check.Set(ctx, key, value, params); err != nil {
+		resp.Diagnostics.AddError("Client Error", fmt.Sprintf("Unable to set config: %s", interrupted(ctx, err)))
 		return
 	}
 
@@ -240,30 +308,44 @@ func (r *CiliumConfigResource) Update(ctx context.Context, req resource.UpdateRe
 
 func (r *CiliumConfigResource) Delete(ctx context.Context, req resource.DeleteRequest, resp *resource.DeleteResponse) {
 	var data CiliumConfigResourceModel
-	c := r.client
+
+	// Read Terraform prior state data into the model
+	resp.Diagnostics.Append(req.State.Get(ctx, &data)...)
+
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	c := r.client.ForRelease(data.Namespace, data.HelmRelease)
 	if c == nil {
 		resp.Diagnostics.AddError("Client Error", "Unable to connect to kubernetes")
 		return
 	}
-	k8sClient, namespace := c.client, c.namespace
+	k8sClient, err := c.K8sClient()
+	if err != nil {
+		resp.Diagnostics.AddError("Client Error", fmt.Sprintf("Unable to connect to kubernetes: %s", err))
+		return
+	}
+	namespace := c.namespace
 	var params = config.Parameters{
-		Writer: os.Stdout,
+		Writer: c.LogWriter(ctx, "cilium_config"),
 	}
 
-	// Read Terraform prior state data into the model
-	resp.Diagnostics.Append(req.State.Get(ctx, &data)...)
-
+	deleteTimeout, diags := data.Timeouts.Delete(ctx, defaults.StatusWaitDuration)
+	resp.Diagnostics.Append(diags...)
 	if resp.Diagnostics.HasError() {
 		return
 	}
+	ctx, cancel := context.WithTimeout(ctx, deleteTimeout)
+	defer cancel()
 
 	params.Namespace = namespace
 	key := data.Key.ValueString()
 	params.Restart = data.Restart.ValueBool()
 
 	check := config.NewK8sConfig(k8sClient, params)
-	if err := check.Delete(context.Background(), key, params); err != nil {
-		resp.Diagnostics.AddError("Client Error", fmt.Sprintf("Unable to delete config: %s", err))
+	if err := check.Delete(ctx, key, params); err != nil {
+		resp.Diagnostics.AddError("Client Error", fmt.Sprintf("Unable to delete config: %s", interrupted(ctx, err)))
 		return
 	}
 }
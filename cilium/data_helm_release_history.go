@@ -0,0 +1,185 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package provider
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/hashicorp/terraform-plugin-framework/datasource"
+	"github.com/hashicorp/terraform-plugin-framework/datasource/schema"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+	"github.com/hashicorp/terraform-plugin-log/tflog"
+)
+
+// Ensure provider defined types fully satisfy framework interfaces.
+var _ datasource.DataSource = &CiliumHelmReleaseHistoryDataSource{}
+
+func NewCiliumHelmReleaseHistoryDataSource() datasource.DataSource {
+	return &CiliumHelmReleaseHistoryDataSource{}
+}
+
+// CiliumHelmReleaseHistoryDataSource defines the data source implementation.
+type CiliumHelmReleaseHistoryDataSource struct {
+	client *CiliumClient
+}
+
+// CiliumHelmReleaseHistoryDataSourceModel describes the data source data model.
+type CiliumHelmReleaseHistoryDataSourceModel struct {
+	Revisions []CiliumHelmReleaseRevisionModel `tfsdk:"revisions"`
+}
+
+// CiliumHelmReleaseRevisionModel describes a revision of the release.
+type CiliumHelmReleaseRevisionModel struct {
+	Revision     types.Int64  `tfsdk:"revision"`
+	Status       types.String `tfsdk:"status"`
+	ChartVersion types.String `tfsdk:"chart_version"`
+	AppVersion   types.String `tfsdk:"app_version"`
+	Updated      types.String `tfsdk:"updated"`
+	Description  types.String `tfsdk:"description"`
+	Values       types.String `tfsdk:"values"`
+}
+
+func (d *CiliumHelmReleaseHistoryDataSource) Metadata(ctx context.Context, req datasource.MetadataRequest, resp *datasource.MetadataResponse) {
+	resp.TypeName = req.ProviderTypeName + "_helm_release_history"
+}
+
+func (d *CiliumHelmReleaseHistoryDataSource) Schema(ctx context.Context, req datasource.SchemaRequest, resp *datasource.SchemaResponse) {
+	resp.Schema = schema.Schema{
+		// This description is used by the documentation generator and the language server.
+		MarkdownDescription: "Revisions of the Helm release of cilium (`helm history -n kube-system cilium`)",
+
+		Attributes: map[string]schema.Attribute{
+			"revisions": schema.ListNestedAttribute{
+				MarkdownDescription: "Revisions of the release, from the oldest to the newest",
+				Computed:            true,
+				NestedObject: schema.NestedAttributeObject{
+					Attributes: map[string]schema.Attribute{
+						"revision": schema.Int64Attribute{
+							MarkdownDescription: "Revision number",
+							Computed:            true,
+						},
+						"status": schema.StringAttribute{
+							MarkdownDescription: "Status of the revision (e.g. `deployed`, `superseded`, `failed`)",
+							Computed:            true,
+						},
+						"chart_version": schema.StringAttribute{
+							MarkdownDescription: "Version of the chart",
+							Computed:            true,
+						},
+						"app_version": schema.StringAttribute{
+							MarkdownDescription: "Version of Cilium",
+							Computed:            true,
+						},
+						"updated": schema.StringAttribute{
+							MarkdownDescription: "Date of the deployment of the revision (RFC 3339), null if it was not deployed",
+							Computed:            true,
+						},
+						"description": schema.StringAttribute{
+							MarkdownDescription: "Description of the revision (e.g. `Upgrade complete`)",
+							Computed:            true,
+						},
+						"values": schema.StringAttribute{
+							MarkdownDescription: "Helm values of the revision in yaml. They may hold the values of `set_sensitive`",
+							Computed:            true,
+							Sensitive:           true,
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+func (d *CiliumHelmReleaseHistoryDataSource) Configure(ctx context.Context, req datasource.ConfigureRequest, resp *datasource.ConfigureResponse) {
+	// Prevent panic if the provider has not been configured.
+	if req.ProviderData == nil {
+		return
+	}
+
+	client, ok := req.ProviderData.(*CiliumClient)
+
+	if !ok {
+		resp.Diagnostics.AddError(
+			"Unexpected Data Source Configure Type",
+			fmt.Sprintf("Expected *CiliumClient, got: %T. Please report this issue to the provider developers.", req.ProviderData),
+		)
+
+		return
+	}
+
+	d.client = client
+}
+
+func (d *CiliumHelmReleaseHistoryDataSource) Read(ctx context.Context, req datasource.ReadRequest, resp *datasource.ReadResponse) {
+	var data CiliumHelmReleaseHistoryDataSourceModel
+	c := d.client
+	if c == nil {
+		resp.Diagnostics.AddError("Client Error", "Unable to connect to kubernetes")
+		return
+	}
+	k8sClient, err := c.K8sClient()
+	if errors.Is(err, errProviderConfigUnknown) {
+		providerConfigUnknown(req, resp)
+		return
+	}
+	if err != nil {
+		resp.Diagnostics.AddError("Client Error", fmt.Sprintf("Unable to connect to kubernetes: %s", err))
+		return
+	}
+
+	// Read Terraform configuration data into the model
+	resp.Diagnostics.Append(req.Config.Get(ctx, &data)...)
+
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	history, err := k8sClient.HelmActionConfig.Releases.History(c.helm_release)
+	if err != nil && !isNotFound(err) {
+		resp.Diagnostics.AddError("Client Error", fmt.Sprintf("Unable to read Cilium release history: %s", err))
+		return
+	}
+	sort.Slice(history, func(i, j int) bool { return history[i].Version < history[j].Version })
+
+	data.Revisions = []CiliumHelmReleaseRevisionModel{}
+	for _, rel := range history {
+		// Another chart may have been installed as a release of the same name.
+		if !c.ownsRelease(rel) {
+			continue
+		}
+		snapshot := &ReleaseSnapshot{client: c, Release: rel}
+		values, err := snapshot.Values()
+		if err != nil {
+			resp.Diagnostics.AddError("Client Error", fmt.Sprintf("Failed: %s", err))
+			return
+		}
+		revision := CiliumHelmReleaseRevisionModel{
+			Revision:     types.Int64Value(int64(rel.Version)),
+			Status:       types.StringValue(rel.Info.Status.String()),
+			ChartVersion: types.StringNull(),
+			AppVersion:   types.StringValue(snapshot.Version()),
+			Updated:      types.StringNull(),
+			Description:  types.StringValue(rel.Info.Description),
+			Values:       types.StringValue(values),
+		}
+		if !rel.Info.LastDeployed.IsZero() {
+			revision.Updated = types.StringValue(rel.Info.LastDeployed.Format(time.RFC3339))
+		}
+		if rel.Chart != nil && rel.Chart.Metadata != nil {
+			revision.ChartVersion = types.StringValue(rel.Chart.Metadata.Version)
+		}
+		data.Revisions = append(data.Revisions, revision)
+	}
+
+	// Write logs using the tflog package
+	// Documentation: https://terraform.io/plugin/log
+	tflog.Trace(ctx, "read a data source")
+
+	// Save data into Terraform state
+	resp.Diagnostics.Append(resp.State.Set(ctx, &data)...)
+}
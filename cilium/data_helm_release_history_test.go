@@ -0,0 +1,110 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package provider
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/release"
+	"helm.sh/helm/v3/pkg/storage/driver"
+	helmtime "helm.sh/helm/v3/pkg/time"
+
+	"github.com/hashicorp/terraform-plugin-framework/datasource"
+	"github.com/hashicorp/terraform-plugin-framework/datasource/schema"
+	"github.com/hashicorp/terraform-plugin-framework/provider"
+	"github.com/hashicorp/terraform-plugin-framework/tfsdk"
+	"github.com/hashicorp/terraform-plugin-go/tftypes"
+	"github.com/hashicorp/terraform-plugin-testing/helper/resource"
+)
+
+func TestAccCiliumHelmReleaseHistoryDataSource(t *testing.T) {
+	resource.Test(t, resource.TestCase{
+		PreCheck:                 func() { testAccPreCheck(t) },
+		ProtoV6ProviderFactories: testAccProtoV6ProviderFactories,
+		Steps: []resource.TestStep{
+			// Create and Read testing
+			{
+				Config: testAccCiliumHelmReleaseHistoryDataSourceConfig(),
+				Check: resource.ComposeAggregateTestCheckFunc(
+					resource.TestCheckResourceAttr("data.cilium_helm_release_history.test", "revisions.#", "1"),
+					resource.TestCheckResourceAttr("data.cilium_helm_release_history.test", "revisions.0.revision", "1"),
+					resource.TestCheckResourceAttr("data.cilium_helm_release_history.test", "revisions.0.status", "deployed"),
+					resource.TestCheckResourceAttr("data.cilium_helm_release_history.test", "revisions.0.chart_version", "1.16.1"),
+				),
+			},
+			// Delete testing automatically occurs in TestCase
+		},
+	})
+}
+
+func testAccCiliumHelmReleaseHistoryDataSourceConfig() string {
+	return `
+resource "cilium" "test" {
+  version = "1.16.1"
+}
+
+data "cilium_helm_release_history" "test" {
+
+  depends_on = [
+	  cilium.test
+  ]
+}
+`
+}
+
+// Only the revisions of the Cilium release are listed: the other namespaces
+// and the other charts are skipped.
+func TestCiliumHelmReleaseHistoryDataSourceRead(t *testing.T) {
+	srv := testFakeCluster(t, "kind-test", nil)
+	configure := testProviderConfigure(t, map[string]tftypes.Value{
+		"config_content": tftypes.NewValue(tftypes.String, testKubeConfig(srv.URL, "kind-test")),
+		"helm_driver":    tftypes.NewValue(tftypes.String, "memory"),
+	}, provider.ConfigureProviderClientCapabilities{})
+	c := configure.DataSourceData.(*CiliumClient)
+	if _, err := c.K8sClient(); err != nil {
+		t.Fatal(err)
+	}
+	deployed := helmtime.Time{Time: time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)}
+	for _, rel := range []*release.Release{
+		{Namespace: "kube-system", Version: 1, Info: &release.Info{Status: release.StatusFailed}, Chart: &chart.Chart{Metadata: &chart.Metadata{Name: "cilium", Version: "1.17.2"}}},
+		{Namespace: "kube-system", Version: 2, Info: &release.Info{Status: release.StatusDeployed, LastDeployed: deployed}, Chart: &chart.Chart{Metadata: &chart.Metadata{Name: "cilium", Version: "1.17.3"}}},
+		{Namespace: "kube-system", Version: 3, Info: &release.Info{Status: release.StatusFailed}, Chart: &chart.Chart{Metadata: &chart.Metadata{Name: "other", Version: "1.0.0"}}},
+		{Namespace: "cilium-system", Version: 4, Info: &release.Info{Status: release.StatusDeployed}, Chart: &chart.Chart{Metadata: &chart.Metadata{Name: "cilium", Version: "1.18.0"}}},
+	} {
+		rel.Name = "cilium"
+		if err := c.client.HelmActionConfig.Releases.Create(rel); err != nil {
+			t.Fatal(err)
+		}
+	}
+	c.client.HelmActionConfig.Releases.Driver.(*driver.Memory).SetNamespace("")
+
+	ctx := context.Background()
+	d := NewCiliumHelmReleaseHistoryDataSource()
+	d.(datasource.DataSourceWithConfigure).Configure(ctx, datasource.ConfigureRequest{ProviderData: c}, &datasource.ConfigureResponse{})
+	schemaResp := &datasource.SchemaResponse{}
+	d.Schema(ctx, datasource.SchemaRequest{}, schemaResp)
+	objectType := schemaResp.Schema.Type().TerraformType(ctx).(tftypes.Object)
+	config := tfsdk.Config{Schema: schemaResp.Schema, Raw: tftypes.NewValue(objectType, map[string]tftypes.Value{
+		"revisions": tftypes.NewValue(objectType.AttributeTypes["revisions"], nil),
+	})}
+	resp := &datasource.ReadResponse{State: tfsdk.State{Schema: schemaResp.Schema}}
+	d.Read(ctx, datasource.ReadRequest{Config: config}, resp)
+	if resp.Diagnostics.HasError() {
+		t.Fatalf("unexpected diagnostics: %v", resp.Diagnostics)
+	}
+	var data CiliumHelmReleaseHistoryDataSourceModel
+	resp.Diagnostics.Append(resp.State.Get(ctx, &data)...)
+	if len(data.Revisions) != 2 {
+		t.Fatalf("got %d revisions", len(data.Revisions))
+	}
+	if !data.Revisions[0].Updated.IsNull() || data.Revisions[1].Updated.ValueString() != "2025-01-02T03:04:05Z" {
+		t.Errorf("got updated %s and %s", data.Revisions[0].Updated, data.Revisions[1].Updated)
+	}
+	if !schemaResp.Schema.Attributes["revisions"].(schema.ListNestedAttribute).NestedObject.Attributes["values"].IsSensitive() {
+		t.Error("the values should be sensitive")
+	}
+}
@@ -5,11 +5,9 @@ package provider
 
 import (
 	"context"
+	"errors"
 	"fmt"
 
-	"gopkg.in/yaml.v3"
-	"helm.sh/helm/v3/pkg/action"
-
 	"github.com/hashicorp/terraform-plugin-framework/datasource"
 	"github.com/hashicorp/terraform-plugin-framework/datasource/schema"
 	"github.com/hashicorp/terraform-plugin-framework/types"
@@ -30,7 +28,10 @@ type CiliumHelmValuesDataSource struct {
 
 // ExampleDataSourceModel describes the data source data model.
 type CiliumHelmValuesDataSourceModel struct {
-	Yaml types.String `tfsdk:"yaml"`
+	Namespace   types.String `tfsdk:"namespace"`
+	HelmRelease types.String `tfsdk:"helm_release"`
+	Redact      types.List   `tfsdk:"redact"`
+	Yaml        types.String `tfsdk:"yaml"`
 }
 
 func (d *CiliumHelmValuesDataSource) Metadata(ctx context.Context, req datasource.MetadataRequest, resp *datasource.MetadataResponse) {
@@ -43,6 +44,19 @@ func (d *CiliumHelmValuesDataSource) Schema(ctx context.Context, req datasource.
 		MarkdownDescription: "Helm values of cilium",
 
 		Attributes: map[string]schema.Attribute{
+			"namespace": schema.StringAttribute{
+				MarkdownDescription: ConcatDefault("Namespace of the Cilium release, overriding the one of the provider", "namespace of the provider"),
+				Optional:            true,
+			},
+			"helm_release": schema.StringAttribute{
+				MarkdownDescription: ConcatDefault("Name of the Cilium Helm release, overriding the one of the provider", "helm_release of the provider"),
+				Optional:            true,
+			},
+			"redact": schema.ListAttribute{
+				MarkdownDescription: "Paths of the values replaced by `(sensitive value)` in `yaml`, e.g. the names of `set_sensitive` of the `cilium` resource",
+				ElementType:         types.StringType,
+				Optional:            true,
+			},
 			"yaml": schema.StringAttribute{
 				MarkdownDescription: "Yaml output",
 				Computed:            true,
@@ -73,12 +87,6 @@ func (d *CiliumHelmValuesDataSource) Configure(ctx context.Context, req datasour
 
 func (d *CiliumHelmValuesDataSource) Read(ctx context.Context, req datasource.ReadRequest, resp *datasource.ReadResponse) {
 	var data CiliumHelmValuesDataSourceModel
-	c := d.client
-	if c == nil {
-		resp.Diagnostics.AddError("Client Error", "Unable to connect to kubernetes")
-		return
-	}
-	k8sClient, namespace, helm_release := c.client, c.namespace, c.helm_release
 
 	// Read Terraform configuration data into the model
 	resp.Diagnostics.Append(req.Config.Get(ctx, &data)...)
@@ -86,30 +94,44 @@ func (d *CiliumHelmValuesDataSource) Read(ctx context.Context, req datasource.Re
 	if resp.Diagnostics.HasError() {
 		return
 	}
-	actionConfig := action.Configuration{}
-	logger := func(format string, v ...interface{}) {}
-	helmDriver := ""
-	if err := actionConfig.Init(k8sClient.RESTClientGetter, namespace, helmDriver, logger); err != nil {
+
+	c := d.client.ForRelease(data.Namespace, data.HelmRelease)
+	if c == nil {
+		resp.Diagnostics.AddError("Client Error", "Unable to connect to kubernetes")
+		return
+	}
+	_, err := c.K8sClient()
+	if errors.Is(err, errProviderConfigUnknown) {
+		providerConfigUnknown(req, resp)
+		return
+	}
+	if err != nil {
+		resp.Diagnostics.AddError("Client Error", fmt.Sprintf("Unable to connect to kubernetes: %s", err))
+		return
+	}
+	snapshot, err := c.GetReleaseSnapshot()
+	if isNotFound(err) {
 		return
 	}
-
-	client := action.NewGetValues(&actionConfig)
-
-	vals, err := client.Run(helm_release)
-
 	if err != nil {
+		resp.Diagnostics.AddError("Client Error", fmt.Sprintf("Unable to read Cilium release: %s", err))
+		return
+	}
+
+	var redact []string
+	resp.Diagnostics.Append(data.Redact.ElementsAs(ctx, &redact, false)...)
+	if resp.Diagnostics.HasError() {
 		return
 	}
+	snapshot.Redact(redact)
 
-	// For the purposes of this example code, hardcoding a response value to
-	// save into the Terraform state.
-	yaml, err := yaml.Marshal(vals)
+	yaml, err := snapshot.Values()
 	if err != nil {
 		resp.Diagnostics.AddError("Client Error", fmt.Sprintf("Failed: %s", err))
 		return
 	}
 
-	data.Yaml = types.StringValue(string(yaml))
+	data.Yaml = types.StringValue(yaml)
 
 	// Write logs using the tflog package
 	// Documentation: https://terraform.io/plugin/log
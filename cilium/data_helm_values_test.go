@@ -4,8 +4,14 @@
 package provider
 
 import (
+	"context"
+	"strings"
 	"testing"
 
+	"github.com/hashicorp/terraform-plugin-framework/datasource"
+	"github.com/hashicorp/terraform-plugin-framework/provider"
+	"github.com/hashicorp/terraform-plugin-framework/tfsdk"
+	"github.com/hashicorp/terraform-plugin-go/tftypes"
 	"github.com/hashicorp/terraform-plugin-testing/helper/resource"
 )
 
@@ -40,3 +46,80 @@ data "cilium_helm_values" "test" {
 }
 `
 }
+
+// The provider configuration is unknown during the plan when the cluster is
+// created in the same apply.
+func TestDataSourcesProviderConfigUnknown(t *testing.T) {
+	configure := testProviderConfigure(t, map[string]tftypes.Value{
+		"host": tftypes.NewValue(tftypes.String, tftypes.UnknownValue),
+	}, provider.ConfigureProviderClientCapabilities{})
+	for _, d := range []datasource.DataSource{NewCiliumHelmValuesDataSource(), NewCiliumHelmReleaseHistoryDataSource()} {
+		ctx := context.Background()
+		d.(datasource.DataSourceWithConfigure).Configure(ctx, datasource.ConfigureRequest{ProviderData: configure.DataSourceData}, &datasource.ConfigureResponse{})
+		schemaResp := &datasource.SchemaResponse{}
+		d.Schema(ctx, datasource.SchemaRequest{}, schemaResp)
+		objectType := schemaResp.Schema.Type().TerraformType(ctx).(tftypes.Object)
+		values := map[string]tftypes.Value{}
+		for name, attributeType := range objectType.AttributeTypes {
+			values[name] = tftypes.NewValue(attributeType, nil)
+		}
+		config := tfsdk.Config{Schema: schemaResp.Schema, Raw: tftypes.NewValue(objectType, values)}
+
+		resp := &datasource.ReadResponse{State: tfsdk.State{Schema: schemaResp.Schema}}
+		d.Read(ctx, datasource.ReadRequest{Config: config, ClientCapabilities: datasource.ReadClientCapabilities{DeferralAllowed: true}}, resp)
+		if resp.Diagnostics.HasError() || resp.Deferred == nil || resp.Deferred.Reason != datasource.DeferredReasonProviderConfigUnknown {
+			t.Errorf("%T: the read should be deferred, got %v %v", d, resp.Deferred, resp.Diagnostics)
+		}
+
+		resp = &datasource.ReadResponse{State: tfsdk.State{Schema: schemaResp.Schema}}
+		d.Read(ctx, datasource.ReadRequest{Config: config}, resp)
+		if !resp.Diagnostics.HasError() || resp.Deferred != nil {
+			t.Errorf("%T: expected an error diagnostic", d)
+		}
+	}
+}
+
+// The values of redact are hidden from yaml, which is not sensitive.
+func TestCiliumHelmValuesDataSourceRedact(t *testing.T) {
+	const secret = "s3cr3t-key"
+	srv := testFakeCluster(t, "kind-test", nil)
+	configure := testProviderConfigure(t, map[string]tftypes.Value{
+		"config_content": tftypes.NewValue(tftypes.String, testKubeConfig(srv.URL, "kind-test")),
+		"helm_driver":    tftypes.NewValue(tftypes.String, "memory"),
+	}, provider.ConfigureProviderClientCapabilities{})
+	c := configure.DataSourceData.(*CiliumClient)
+	if _, err := c.K8sClient(); err != nil {
+		t.Fatal(err)
+	}
+	testCreateRelease(t, c, map[string]interface{}{
+		"hubble": map[string]interface{}{"tls": map[string]interface{}{"server": map[string]interface{}{"key": secret}}},
+	})
+
+	ctx := context.Background()
+	d := NewCiliumHelmValuesDataSource()
+	d.(datasource.DataSourceWithConfigure).Configure(ctx, datasource.ConfigureRequest{ProviderData: c}, &datasource.ConfigureResponse{})
+	schemaResp := &datasource.SchemaResponse{}
+	d.Schema(ctx, datasource.SchemaRequest{}, schemaResp)
+	if schemaResp.Schema.Attributes["yaml"].IsSensitive() {
+		t.Error("yaml should not be sensitive")
+	}
+	objectType := schemaResp.Schema.Type().TerraformType(ctx).(tftypes.Object)
+	values := map[string]tftypes.Value{}
+	for name, attributeType := range objectType.AttributeTypes {
+		values[name] = tftypes.NewValue(attributeType, nil)
+	}
+	values["redact"] = tftypes.NewValue(tftypes.List{ElementType: tftypes.String}, []tftypes.Value{
+		tftypes.NewValue(tftypes.String, "hubble.tls.server.key"),
+	})
+	config := tfsdk.Config{Schema: schemaResp.Schema, Raw: tftypes.NewValue(objectType, values)}
+	resp := &datasource.ReadResponse{State: tfsdk.State{Schema: schemaResp.Schema}}
+	d.Read(ctx, datasource.ReadRequest{Config: config}, resp)
+	if resp.Diagnostics.HasError() {
+		t.Fatalf("unexpected diagnostics: %v", resp.Diagnostics)
+	}
+	var data CiliumHelmValuesDataSourceModel
+	resp.Diagnostics.Append(resp.State.Get(ctx, &data)...)
+	if strings.Contains(data.Yaml.ValueString(), secret) || !strings.Contains(data.Yaml.ValueString(), sensitiveValue) {
+		t.Errorf("got yaml %s", data.Yaml)
+	}
+}
@@ -0,0 +1,117 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package provider
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/hashicorp/terraform-plugin-framework/ephemeral"
+	"github.com/hashicorp/terraform-plugin-framework/ephemeral/schema"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+	"github.com/hashicorp/terraform-plugin-log/tflog"
+)
+
+// Ensure provider defined types fully satisfy framework interfaces.
+var _ ephemeral.EphemeralResource = &CiliumCAEphemeralResource{}
+var _ ephemeral.EphemeralResourceWithConfigure = &CiliumCAEphemeralResource{}
+
+func NewCiliumCAEphemeralResource() ephemeral.EphemeralResource {
+	return &CiliumCAEphemeralResource{}
+}
+
+// CiliumCAEphemeralResource defines the ephemeral resource implementation.
+type CiliumCAEphemeralResource struct {
+	client *CiliumClient
+}
+
+// CiliumCAEphemeralResourceModel describes the ephemeral resource data model.
+type CiliumCAEphemeralResourceModel struct {
+	Crt types.String `tfsdk:"crt"`
+	Key types.String `tfsdk:"key"`
+}
+
+func (r *CiliumCAEphemeralResource) Metadata(ctx context.Context, req ephemeral.MetadataRequest, resp *ephemeral.MetadataResponse) {
+	resp.TypeName = req.ProviderTypeName + "_ca"
+}
+
+func (r *CiliumCAEphemeralResource) Schema(ctx context.Context, req ephemeral.SchemaRequest, resp *ephemeral.SchemaResponse) {
+	resp.Schema = schema.Schema{
+		// This description is used by the documentation generator and the language server.
+		MarkdownDescription: "Cilium CA, read on demand and never stored in the state (Equivalent to `kubectl get secret cilium-ca -n kube-system -o yaml`)",
+
+		Attributes: map[string]schema.Attribute{
+			"crt": schema.StringAttribute{
+				MarkdownDescription: "Certificate of the CA in base 64",
+				Computed:            true,
+			},
+			"key": schema.StringAttribute{
+				MarkdownDescription: "Private key of the CA in base 64",
+				Computed:            true,
+				Sensitive:           true,
+			},
+		},
+	}
+}
+
+func (r *CiliumCAEphemeralResource) Configure(ctx context.Context, req ephemeral.ConfigureRequest, resp *ephemeral.ConfigureResponse) {
+	// Prevent panic if the provider has not been configured.
+	if req.ProviderData == nil {
+		return
+	}
+
+	client, ok := req.ProviderData.(*CiliumClient)
+
+	if !ok {
+		resp.Diagnostics.AddError(
+			"Unexpected Ephemeral Resource Configure Type",
+			fmt.Sprintf("Expected *CiliumClient, got: %T. Please report this issue to the provider developers.", req.ProviderData),
+		)
+
+		return
+	}
+
+	r.client = client
+}
+
+func (r *CiliumCAEphemeralResource) Open(ctx context.Context, req ephemeral.OpenRequest, resp *ephemeral.OpenResponse) {
+	var data CiliumCAEphemeralResourceModel
+	c := r.client
+	if c == nil {
+		resp.Diagnostics.AddError("Client Error", "Unable to connect to kubernetes")
+		return
+	}
+	_, err := c.K8sClient()
+	if errors.Is(err, errProviderConfigUnknown) && req.ClientCapabilities.DeferralAllowed {
+		resp.Deferred = &ephemeral.Deferred{Reason: ephemeral.DeferredReasonProviderConfigUnknown}
+		return
+	}
+	if err != nil {
+		resp.Diagnostics.AddError("Client Error", fmt.Sprintf("Unable to connect to kubernetes: %s", err))
+		return
+	}
+
+	// Read Terraform configuration data into the model
+	resp.Diagnostics.Append(req.Config.Get(ctx, &data)...)
+
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	ca, err := c.GetCA(ctx)
+	if err != nil {
+		resp.Diagnostics.AddError("Client Error", fmt.Sprintf("Unable to retrieve cilium-ca: %s", err))
+		return
+	}
+	data.Crt = ca["crt"].(types.String)
+	data.Key = ca["key"].(types.String)
+
+	// Write logs using the tflog package
+	// Documentation: https://terraform.io/plugin/log
+	tflog.Trace(ctx, "opened an ephemeral resource")
+
+	// Save data into ephemeral result data
+	resp.Diagnostics.Append(resp.Result.Set(ctx, &data)...)
+}
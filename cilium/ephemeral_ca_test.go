@@ -0,0 +1,62 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package provider
+
+import (
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-framework/providerserver"
+	"github.com/hashicorp/terraform-plugin-go/tfprotov6"
+	"github.com/hashicorp/terraform-plugin-testing/echoprovider"
+	"github.com/hashicorp/terraform-plugin-testing/helper/resource"
+	"github.com/hashicorp/terraform-plugin-testing/tfversion"
+)
+
+func TestAccCiliumCAEphemeralResource(t *testing.T) {
+	resource.Test(t, resource.TestCase{
+		TerraformVersionChecks: []tfversion.TerraformVersionCheck{
+			tfversion.SkipBelow(tfversion.Version1_10_0),
+		},
+		PreCheck: func() { testAccPreCheck(t) },
+		ProtoV6ProviderFactories: map[string]func() (tfprotov6.ProviderServer, error){
+			"cilium": providerserver.NewProtocol6WithError(New("test")()),
+			"echo":   echoprovider.NewProviderServer(),
+		},
+		Steps: []resource.TestStep{
+			{
+				Config: testAccCiliumCAEphemeralResourceConfig(),
+				Check: resource.ComposeAggregateTestCheckFunc(
+					resource.TestCheckResourceAttrSet("cilium.test", "ca.crt"),
+					resource.TestCheckNoResourceAttr("cilium.test", "ca.key"),
+					resource.TestCheckResourceAttrPair("echo.test", "data.crt", "cilium.test", "ca.crt"),
+					resource.TestCheckResourceAttrSet("echo.test", "data.key"),
+				),
+			},
+		},
+	})
+}
+
+func testAccCiliumCAEphemeralResourceConfig() string {
+	return `
+provider "cilium" {
+  store_ca_key = false
+}
+
+resource "cilium" "test" {
+  version = "1.16.1"
+}
+
+ephemeral "cilium_ca" "test" {
+  depends_on = [
+    cilium.test
+  ]
+}
+
+provider "echo" {
+  data = ephemeral.cilium_ca.test
+}
+
+resource "echo" "test" {}
+`
+}
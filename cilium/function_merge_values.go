@@ -0,0 +1,59 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package provider
+
+import (
+	"context"
+
+	"github.com/hashicorp/terraform-plugin-framework/function"
+)
+
+// Ensure provider defined types fully satisfy framework interfaces.
+var _ function.Function = &MergeValuesFunction{}
+
+func NewMergeValuesFunction() function.Function {
+	return &MergeValuesFunction{}
+}
+
+// MergeValuesFunction defines the function implementation.
+type MergeValuesFunction struct{}
+
+func (f *MergeValuesFunction) Metadata(ctx context.Context, req function.MetadataRequest, resp *function.MetadataResponse) {
+	resp.Name = "merge_values"
+}
+
+func (f *MergeValuesFunction) Definition(ctx context.Context, req function.DefinitionRequest, resp *function.DefinitionResponse) {
+	resp.Definition = function.Definition{
+		Summary:             "Merge helm values",
+		MarkdownDescription: "Merges yaml documents of helm values the way helm merges values files: maps are merged recursively, any other value is replaced by the last document. The result is encoded like `helm_values`.",
+		VariadicParameter: function.StringParameter{
+			Name:                "values",
+			MarkdownDescription: "Helm values in raw yaml",
+		},
+		Return: function.StringReturn{},
+	}
+}
+
+func (f *MergeValuesFunction) Run(ctx context.Context, req function.RunRequest, resp *function.RunResponse) {
+	var documents []string
+
+	resp.Error = function.ConcatFuncErrors(req.Arguments.Get(ctx, &documents))
+	if resp.Error != nil {
+		return
+	}
+
+	values, err := MergeValues(documents...)
+	if err != nil {
+		resp.Error = function.NewFuncError(err.Error())
+		return
+	}
+
+	yaml, err := ValuesToYaml(values)
+	if err != nil {
+		resp.Error = function.NewFuncError(err.Error())
+		return
+	}
+
+	resp.Error = function.ConcatFuncErrors(resp.Result.Set(ctx, yaml))
+}
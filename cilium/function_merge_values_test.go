@@ -0,0 +1,39 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package provider
+
+import (
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-testing/helper/resource"
+	"github.com/hashicorp/terraform-plugin-testing/tfversion"
+)
+
+func TestAccMergeValuesFunction(t *testing.T) {
+	resource.Test(t, resource.TestCase{
+		TerraformVersionChecks: []tfversion.TerraformVersionCheck{
+			tfversion.SkipBelow(tfversion.Version1_8_0),
+		},
+		ProtoV6ProviderFactories: testAccProtoV6ProviderFactories,
+		Steps: []resource.TestStep{
+			{
+				Config: testAccMergeValuesFunctionConfig(),
+				Check: resource.ComposeAggregateTestCheckFunc(
+					resource.TestCheckOutput("test", "hubble:\n    relay:\n        enabled: true\n    ui:\n        enabled: false\nipam:\n    mode: kubernetes\n"),
+				),
+			},
+		},
+	})
+}
+
+func testAccMergeValuesFunctionConfig() string {
+	return `
+output "test" {
+  value = provider::cilium::merge_values(
+    yamlencode({ ipam = { mode = "cluster-pool" }, hubble = { ui = { enabled = false } } }),
+    yamlencode({ ipam = { mode = "kubernetes" }, hubble = { relay = { enabled = true } } }),
+  )
+}
+`
+}
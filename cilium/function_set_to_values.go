@@ -0,0 +1,63 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package provider
+
+import (
+	"context"
+
+	"github.com/hashicorp/terraform-plugin-framework/function"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+)
+
+// Ensure provider defined types fully satisfy framework interfaces.
+var _ function.Function = &SetToValuesFunction{}
+
+func NewSetToValuesFunction() function.Function {
+	return &SetToValuesFunction{}
+}
+
+// SetToValuesFunction defines the function implementation.
+type SetToValuesFunction struct{}
+
+func (f *SetToValuesFunction) Metadata(ctx context.Context, req function.MetadataRequest, resp *function.MetadataResponse) {
+	resp.Name = "set_to_values"
+}
+
+func (f *SetToValuesFunction) Definition(ctx context.Context, req function.DefinitionRequest, resp *function.DefinitionResponse) {
+	resp.Definition = function.Definition{
+		Summary:             "Convert set entries to helm values",
+		MarkdownDescription: "Converts a list of `set` entries (`key1=val1,key2=val2`) to helm values in raw yaml, with the same parser helm uses for `--set`.",
+		Parameters: []function.Parameter{
+			function.ListParameter{
+				Name:                "set",
+				ElementType:         types.StringType,
+				MarkdownDescription: "List of set entries",
+			},
+		},
+		Return: function.StringReturn{},
+	}
+}
+
+func (f *SetToValuesFunction) Run(ctx context.Context, req function.RunRequest, resp *function.RunResponse) {
+	var set []string
+
+	resp.Error = function.ConcatFuncErrors(req.Arguments.Get(ctx, &set))
+	if resp.Error != nil {
+		return
+	}
+
+	values, err := SetToValues(set)
+	if err != nil {
+		resp.Error = function.NewArgumentFuncError(0, err.Error())
+		return
+	}
+
+	yaml, err := ValuesToYaml(values)
+	if err != nil {
+		resp.Error = function.NewFuncError(err.Error())
+		return
+	}
+
+	resp.Error = function.ConcatFuncErrors(resp.Result.Set(ctx, yaml))
+}
@@ -0,0 +1,72 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package provider
+
+import (
+	"context"
+
+	"github.com/hashicorp/terraform-plugin-framework/function"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+)
+
+// Ensure provider defined types fully satisfy framework interfaces.
+var _ function.Function = &ValuesGetFunction{}
+
+func NewValuesGetFunction() function.Function {
+	return &ValuesGetFunction{}
+}
+
+// ValuesGetFunction defines the function implementation.
+type ValuesGetFunction struct{}
+
+func (f *ValuesGetFunction) Metadata(ctx context.Context, req function.MetadataRequest, resp *function.MetadataResponse) {
+	resp.Name = "values_get"
+}
+
+func (f *ValuesGetFunction) Definition(ctx context.Context, req function.DefinitionRequest, resp *function.DefinitionResponse) {
+	resp.Definition = function.Definition{
+		Summary:             "Get a helm value",
+		MarkdownDescription: "Returns the helm value found at a dotted path such as `hubble.relay.enabled`, or null when it is not set. A dot inside a key is escaped with a backslash.",
+		Parameters: []function.Parameter{
+			function.StringParameter{
+				Name:                "values",
+				MarkdownDescription: "Helm values in raw yaml",
+			},
+			function.StringParameter{
+				Name:                "key",
+				MarkdownDescription: "Dotted path of the value",
+			},
+		},
+		Return: function.DynamicReturn{},
+	}
+}
+
+func (f *ValuesGetFunction) Run(ctx context.Context, req function.RunRequest, resp *function.RunResponse) {
+	var document, key string
+
+	resp.Error = function.ConcatFuncErrors(req.Arguments.Get(ctx, &document, &key))
+	if resp.Error != nil {
+		return
+	}
+
+	values, err := MergeValues(document)
+	if err != nil {
+		resp.Error = function.NewArgumentFuncError(0, err.Error())
+		return
+	}
+
+	v, ok := GetValue(values, key)
+	if !ok || v == nil {
+		resp.Error = function.ConcatFuncErrors(resp.Result.Set(ctx, types.DynamicNull()))
+		return
+	}
+
+	value, err := ValueToTerraform(v)
+	if err != nil {
+		resp.Error = function.NewFuncError(err.Error())
+		return
+	}
+
+	resp.Error = function.ConcatFuncErrors(resp.Result.Set(ctx, types.DynamicValue(value)))
+}
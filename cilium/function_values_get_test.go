@@ -0,0 +1,50 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package provider
+
+import (
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-testing/helper/resource"
+	"github.com/hashicorp/terraform-plugin-testing/tfversion"
+)
+
+func TestAccValuesGetFunction(t *testing.T) {
+	resource.Test(t, resource.TestCase{
+		TerraformVersionChecks: []tfversion.TerraformVersionCheck{
+			tfversion.SkipBelow(tfversion.Version1_8_0),
+		},
+		ProtoV6ProviderFactories: testAccProtoV6ProviderFactories,
+		Steps: []resource.TestStep{
+			{
+				Config: testAccValuesGetFunctionConfig(),
+				Check: resource.ComposeAggregateTestCheckFunc(
+					resource.TestCheckOutput("enabled", "true"),
+					resource.TestCheckOutput("replicas", "2"),
+					resource.TestCheckNoResourceAttr("terraform_data.test", "output"),
+				),
+			},
+		},
+	})
+}
+
+func testAccValuesGetFunctionConfig() string {
+	return `
+locals {
+  values = "hubble:\n  relay:\n    enabled: true\noperator:\n  replicas: 2\n"
+}
+
+output "enabled" {
+  value = provider::cilium::values_get(local.values, "hubble.relay.enabled")
+}
+
+output "replicas" {
+  value = provider::cilium::values_get(local.values, "operator.replicas")
+}
+
+resource "terraform_data" "test" {
+  input = provider::cilium::values_get(local.values, "hubble.ui.enabled")
+}
+`
+}
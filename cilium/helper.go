@@ -1,25 +1,56 @@
 package provider
 
 import (
+	"bytes"
 	"context"
 	"encoding/base64"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"io/fs"
+	"math/big"
 	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/util/homedir"
 
+	"github.com/cilium/charts"
 	"github.com/cilium/cilium/cilium-cli/clustermesh"
 	"github.com/cilium/cilium/cilium-cli/defaults"
+	"github.com/cilium/cilium/cilium-cli/install"
 	"github.com/cilium/cilium/cilium-cli/k8s"
 	"github.com/cilium/cilium/cilium-cli/status"
 
 	"gopkg.in/yaml.v3"
 	"helm.sh/helm/v3/pkg/action"
+	"helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/chart/loader"
+	"helm.sh/helm/v3/pkg/chartutil"
+	"helm.sh/helm/v3/pkg/cli"
+	"helm.sh/helm/v3/pkg/downloader"
+	"helm.sh/helm/v3/pkg/registry"
 	"helm.sh/helm/v3/pkg/release"
+	"helm.sh/helm/v3/pkg/releaseutil"
+	"helm.sh/helm/v3/pkg/storage/driver"
+	"helm.sh/helm/v3/pkg/strvals"
 
 	"github.com/hashicorp/terraform-plugin-framework/attr"
+	"github.com/hashicorp/terraform-plugin-framework/datasource"
+	"github.com/hashicorp/terraform-plugin-framework/resource/schema"
+	"github.com/hashicorp/terraform-plugin-framework/resource/schema/planmodifier"
+	"github.com/hashicorp/terraform-plugin-framework/resource/schema/stringplanmodifier"
 	"github.com/hashicorp/terraform-plugin-framework/types"
+	"github.com/hashicorp/terraform-plugin-go/tftypes"
+	"github.com/hashicorp/terraform-plugin-log/tflog"
 )
 
 var CaAttributeTypes = map[string]attr.Type{
@@ -27,63 +58,733 @@ var CaAttributeTypes = map[string]attr.Type{
 	"key": types.StringType,
 }
 
+// errDaemonsetRunning is returned when kube-proxy runs again.
+var errDaemonsetRunning = errors.New("replicas count is not zero")
+
+// errProviderConfigUnknown is returned when the provider configuration
+// depends on values which are only known after apply.
+var errProviderConfigUnknown = errors.New("the provider configuration is not known yet, it depends on values known after apply")
+
+// providerConfigUnknown answers the read of a data source while the provider
+// configuration is unknown: the read is deferred when Terraform supports it.
+// Terraform rejects a data source which is not wholly known, so the read fails
+// otherwise.
+func providerConfigUnknown(req datasource.ReadRequest, resp *datasource.ReadResponse) {
+	if req.ClientCapabilities.DeferralAllowed {
+		resp.Deferred = &datasource.Deferred{Reason: datasource.DeferredReasonProviderConfigUnknown}
+		return
+	}
+	resp.Diagnostics.AddError("Provider Configuration Unknown", fmt.Sprintf("Unable to read the Cilium release: %s. Create the cluster in a previous apply (e.g. with `-target`), or use a Terraform version supporting deferred actions.", errProviderConfigUnknown))
+}
+
 type CiliumClient struct {
-	client       *k8s.Client
-	namespace    string
-	helm_release string
+	client             *k8s.Client
+	connect            func(helmNamespace string) (*k8s.Client, error)
+	mutex              sync.Mutex
+	namespace          string
+	helm_release       string
+	helm_namespace     string
+	impersonate_as     string
+	impersonate_groups []string
+	store_ca_key       bool
+	// releases are the clients of the releases of the resources which
+	// override the namespace or the helm_release of the provider.
+	releases map[string]*CiliumClient
+	// provider is the client of the provider of a release client.
+	provider *CiliumClient
+	// connectContext creates the client of another context of the kubeconfig
+	// of the provider, e.g. a remote cluster of Cluster Mesh.
+	connectContext func(contextName, helmNamespace string) (*k8s.Client, error)
+	// remotes are the clients of the remote clusters of Cluster Mesh by
+	// context and helm namespace.
+	remotes map[string]*k8s.Client
+}
+
+// helmRetryInterval is the delay between two attempts of a Helm mutation while
+// another operation is pending on the release.
+var helmRetryInterval = defaults.WaitRetryInterval
+
+// helmPendingAttempts bounds the attempts of a Helm mutation on a pending
+// release: a release left pending by an interrupted operation never recovers.
+var helmPendingAttempts = 15
+
+// K8sClient returns the Kubernetes client, creating it on first use: the
+// cluster may not exist yet when the provider is configured. It must be called
+// before using the other CiliumClient methods.
+func (c *CiliumClient) K8sClient() (*k8s.Client, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.client == nil {
+		client, err := c.connect(c.helmNamespace())
+		if err != nil {
+			return nil, err
+		}
+		c.client = client
+	}
+	return c.client, nil
+}
+
+// helmNamespace returns the namespace where helm stores the release.
+func (c *CiliumClient) helmNamespace() string {
+	if c.helm_namespace != "" {
+		return c.helm_namespace
+	}
+	return c.namespace
+}
+
+// ForRelease returns the client of the release of a resource: namespace and
+// helmRelease override the ones of the provider when they are set. A release
+// client shares the locks of the provider, and its Kubernetes client when helm
+// stores both releases in the same namespace.
+func (c *CiliumClient) ForRelease(namespace, helmRelease types.String) *CiliumClient {
+	if c == nil {
+		return nil
+	}
+	releaseNamespace, releaseName := c.namespace, c.helm_release
+	if v := namespace.ValueString(); v != "" {
+		releaseNamespace = v
+	}
+	if v := helmRelease.ValueString(); v != "" {
+		releaseName = v
+	}
+	if releaseNamespace == c.namespace && releaseName == c.helm_release {
+		return c
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	key := releaseNamespace + "/" + releaseName
+	if client, ok := c.releases[key]; ok {
+		return client
+	}
+	client := &CiliumClient{
+		namespace:          releaseNamespace,
+		helm_release:       releaseName,
+		helm_namespace:     c.helm_namespace,
+		impersonate_as:     c.impersonate_as,
+		impersonate_groups: c.impersonate_groups,
+		store_ca_key:       c.store_ca_key,
+		provider:           c,
+	}
+	client.connect = func(helmNamespace string) (*k8s.Client, error) {
+		if helmNamespace == c.helmNamespace() {
+			return c.K8sClient()
+		}
+		return c.connect(helmNamespace)
+	}
+	if c.releases == nil {
+		c.releases = map[string]*CiliumClient{}
+	}
+	c.releases[key] = client
+	return client
+}
+
+// releaseAttributes returns the namespace and helm_release attributes of a
+// resource, which override the ones of the provider. Another release is
+// another resource: changing them replaces the resource, unless the release
+// stays the same (e.g. the namespace of the provider is now set explicitly).
+func releaseAttributes(client func() *CiliumClient) (schema.StringAttribute, schema.StringAttribute) {
+	requiresReplace := func(providerValue func(*CiliumClient) string) planmodifier.String {
+		return stringplanmodifier.RequiresReplaceIf(func(ctx context.Context, req planmodifier.StringRequest, resp *stringplanmodifier.RequiresReplaceIfFuncResponse) {
+			value := func(v types.String) string {
+				if c := client(); v.IsNull() && c != nil {
+					return providerValue(c)
+				}
+				return v.ValueString()
+			}
+			resp.RequiresReplace = value(req.StateValue) != value(req.PlanValue)
+		}, "Changing the release replaces the resource.", "Changing the release replaces the resource.")
+	}
+	namespace := schema.StringAttribute{
+		MarkdownDescription: ConcatDefault("Namespace of the Cilium release, overriding the one of the provider. Changing it replaces the resource", "namespace of the provider"),
+		Optional:            true,
+		PlanModifiers:       []planmodifier.String{requiresReplace(func(c *CiliumClient) string { return c.namespace })},
+	}
+	helmRelease := schema.StringAttribute{
+		MarkdownDescription: ConcatDefault("Name of the Cilium Helm release, overriding the one of the provider. Changing it replaces the resource", "helm_release of the provider"),
+		Optional:            true,
+		PlanModifiers:       []planmodifier.String{requiresReplace(func(c *CiliumClient) string { return c.helm_release })},
+	}
+	return namespace, helmRelease
+}
+
+// releaseLocks are the locks of LockRelease by cluster and release. They are
+// shared by the provider instances: two aliases may manage the same release.
+var releaseLocks = struct {
+	sync.Mutex
+	locks map[string]*sync.Mutex
+}{locks: map[string]*sync.Mutex{}}
+
+// LockRelease serialises the Helm mutations of a release: Terraform applies
+// the resources in parallel while Helm refuses concurrent operations, and an
+// upgrade computed from stale values would revert the ones of another
+// resource. The release is identified by the API server of the cluster, so
+// that the aliases of the provider share its lock. It returns the unlock
+// function.
+func (c *CiliumClient) LockRelease(namespace, helmRelease string) func() {
+	key := c.host() + "/" + namespace + "/" + helmRelease
+	releaseLocks.Lock()
+	lock, ok := releaseLocks.locks[key]
+	if !ok {
+		lock = &sync.Mutex{}
+		releaseLocks.locks[key] = lock
+	}
+	releaseLocks.Unlock()
+
+	lock.Lock()
+	return lock.Unlock
+}
+
+// host returns the API server of the cluster, empty before the connection.
+func (c *CiliumClient) host() string {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.client != nil && c.client.Config != nil {
+		return c.client.Config.Host
+	}
+	if c.provider != nil {
+		return c.provider.host()
+	}
+	return ""
+}
+
+// HelmMutation runs a Helm mutation of the release with its lock held. The
+// mutation is retried while another Helm operation, e.g. from outside of
+// Terraform, is pending on the release, up to helmPendingAttempts times.
+func (c *CiliumClient) HelmMutation(ctx context.Context, mutation func() error) error {
+	unlock := c.LockRelease(c.namespace, c.helm_release)
+	defer unlock()
+	for attempt := 1; ; attempt++ {
+		err := mutation()
+		if !isPendingOperation(err) {
+			return err
+		}
+		if attempt >= helmPendingAttempts {
+			return fmt.Errorf("%w: the %s/%s release is still pending after %d attempts, set `recover_pending` on the cilium resource if an interrupted operation left it pending", err, c.namespace, c.helm_release, attempt)
+		}
+		tflog.Info(ctx, fmt.Sprintf("Another operation is in progress on the %s/%s release, retrying: %s", c.namespace, c.helm_release, err))
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(helmRetryInterval):
+		}
+	}
+}
+
+// isPendingOperation tells whether a Helm operation failed because another
+// one is in progress on the same release.
+func isPendingOperation(err error) bool {
+	if err == nil {
+		return false
+	}
+	return errors.Is(err, driver.ErrReleaseExists) || strings.Contains(err.Error(), "another operation (install/upgrade/rollback) is in progress")
 }
 
 func ConcatDefault(text string, d string) string {
 	return fmt.Sprintf("%s (Default: `%s`).", text, d)
 }
 
-func (c *CiliumClient) Wait() (err error) {
+// LogWriter returns a writer which forwards the output of cilium-cli to tflog,
+// line by line: Terraform discards the standard output of the provider.
+func (c *CiliumClient) LogWriter(ctx context.Context, resourceType string) io.Writer {
+	ctx = tflog.SetField(ctx, "resource_type", resourceType)
+	ctx = tflog.SetField(ctx, "namespace", c.namespace)
+	ctx = tflog.SetField(ctx, "helm_release", c.helm_release)
+	return &logWriter{ctx: ctx}
+}
+
+type logWriter struct {
+	ctx   context.Context
+	mutex sync.Mutex
+	line  []byte
+}
+
+// Write logs every complete line and keeps the last incomplete one for the
+// next call.
+func (w *logWriter) Write(p []byte) (int, error) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	w.line = append(w.line, p...)
+	for {
+		i := bytes.IndexByte(w.line, '\n')
+		if i < 0 {
+			break
+		}
+		if line := strings.TrimRight(string(w.line[:i]), "\r"); strings.TrimSpace(line) != "" {
+			tflog.Info(w.ctx, line)
+		}
+		w.line = w.line[i+1:]
+	}
+	return len(p), nil
+}
+
+// Wait waits for Cilium status to be ok. It returns the last status collected,
+// also when the wait fails: it tells which component is not ready.
+func (c *CiliumClient) Wait(ctx context.Context, timeout time.Duration) (*status.Status, error) {
 	var status_params = status.K8sStatusParameters{}
 	status_params.Namespace = c.namespace
 	status_params.Wait = true
-	status_params.WaitDuration = defaults.StatusWaitDuration
+	status_params.WaitDuration = timeout
 	collector, err := status.NewK8sStatusCollector(c.client, status_params)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	return collector.Status(ctx)
+}
+
+// StatusComponentAttributeTypes are the pod counts of a Cilium component.
+var StatusComponentAttributeTypes = map[string]attr.Type{
+	"desired":     types.Int64Type,
+	"ready":       types.Int64Type,
+	"available":   types.Int64Type,
+	"unavailable": types.Int64Type,
+}
+
+// StatusAttributeTypes are the attributes of the Cilium status collected by
+// Wait.
+var StatusAttributeTypes = map[string]attr.Type{
+	"components":     types.MapType{ElemType: types.ObjectType{AttrTypes: StatusComponentAttributeTypes}},
+	"images":         types.MapType{ElemType: types.ListType{ElemType: types.StringType}},
+	"pods":           types.Int64Type,
+	"unmanaged_pods": types.Int64Type,
+	"errors":         types.ListType{ElemType: types.StringType},
+}
+
+// StatusValue returns the Cilium status as a value of StatusAttributeTypes,
+// null without status.
+func StatusValue(s *status.Status) types.Object {
+	if s == nil {
+		return types.ObjectNull(StatusAttributeTypes)
+	}
+	components := map[string]attr.Value{}
+	for name, count := range s.PodState {
+		components[name] = types.ObjectValueMust(StatusComponentAttributeTypes, map[string]attr.Value{
+			"desired":     types.Int64Value(int64(count.Desired)),
+			"ready":       types.Int64Value(int64(count.Ready)),
+			"available":   types.Int64Value(int64(count.Available)),
+			"unavailable": types.Int64Value(int64(count.Unavailable)),
+		})
+	}
+	images := map[string]attr.Value{}
+	for name, count := range s.ImageCount {
+		var list []attr.Value
+		for _, image := range sortedKeys(count) {
+			list = append(list, types.StringValue(image))
+		}
+		images[name] = types.ListValueMust(types.StringType, list)
+	}
+	var errs []attr.Value
+	for _, e := range StatusErrors(s) {
+		errs = append(errs, types.StringValue(e))
+	}
+	return types.ObjectValueMust(StatusAttributeTypes, map[string]attr.Value{
+		"components":     types.MapValueMust(types.ObjectType{AttrTypes: StatusComponentAttributeTypes}, components),
+		"images":         types.MapValueMust(types.ListType{ElemType: types.StringType}, images),
+		"pods":           types.Int64Value(int64(s.PodsCount.All)),
+		"unmanaged_pods": types.Int64Value(int64(s.PodsCount.All - s.PodsCount.ByCilium)),
+		"errors":         types.ListValueMust(types.StringType, errs),
+	})
+}
+
+// StatusErrors returns the errors of the Cilium status, one per component and
+// pod (e.g. `cilium: cilium-x2b9z: pod is not ready`), then the errors of the
+// collection itself.
+func StatusErrors(s *status.Status) []string {
+	errs := []string{}
+	if s == nil {
+		return errs
+	}
+	for _, component := range sortedKeys(s.Errors) {
+		for _, pod := range sortedKeys(s.Errors[component]) {
+			count := s.Errors[component][pod]
+			if count == nil {
+				continue
+			}
+			for _, err := range count.Errors {
+				if pod == component {
+					errs = append(errs, fmt.Sprintf("%s: %s", component, err))
+				} else {
+					errs = append(errs, fmt.Sprintf("%s: %s: %s", component, pod, err))
+				}
+			}
+		}
+	}
+	for _, err := range s.CollectionErrors {
+		errs = append(errs, err.Error())
+	}
+	return errs
+}
+
+// StatusSummary describes the components of the Cilium status which are not
+// ready and the errors, to explain a failed wait.
+func StatusSummary(s *status.Status) string {
+	if s == nil {
+		return ""
+	}
+	var b strings.Builder
+	for _, name := range sortedKeys(s.PodState) {
+		count := s.PodState[name]
+		fmt.Fprintf(&b, "\n%s %s: desired %d, ready %d, available %d, unavailable %d", count.Type, name, count.Desired, count.Ready, count.Available, count.Unavailable)
+	}
+	for _, e := range StatusErrors(s) {
+		fmt.Fprintf(&b, "\n%s", e)
+	}
+	return b.String()
+}
+
+// sortedKeys returns the keys of m in order.
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// WaitNamespaceDeleted waits until the namespace is gone. The other errors are
+// retried, as the namespace may still be there. It gives up when ctx is done:
+// Terraform cancels it on interruption and the timeouts bound it.
+func (c *CiliumClient) WaitNamespaceDeleted(ctx context.Context, namespace string) error {
+	for {
+		_, err := c.client.GetNamespace(ctx, namespace, metav1.GetOptions{})
+		if isNotFound(err) {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+		case <-time.After(defaults.WaitRetryInterval):
+			continue
+		}
+		if err != nil && !errors.Is(err, ctx.Err()) {
+			return fmt.Errorf("unable to check that namespace %s is deleted: %w: %w", namespace, err, ctx.Err())
+		}
+		return fmt.Errorf("namespace %s is still terminating: %w", namespace, ctx.Err())
+	}
+}
+
+// isNotFound tells whether err means that the release or the Kubernetes
+// object is genuinely absent, as opposed to a transient error.
+func isNotFound(err error) bool {
+	return errors.Is(err, driver.ErrReleaseNotFound) || k8serrors.IsNotFound(err)
+}
+
+// ClusterMeshEnabled tells whether the clustermesh-apiserver is deployed.
+func (c *CiliumClient) ClusterMeshEnabled(ctx context.Context) (bool, error) {
+	_, err := c.client.GetDeployment(ctx, c.namespace, defaults.ClusterMeshDeploymentName, metav1.GetOptions{})
+	if isNotFound(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// interrupted explains an error caused by the end of the operation context.
+func interrupted(ctx context.Context, err error) error {
+	switch {
+	case errors.Is(ctx.Err(), context.Canceled):
+		return fmt.Errorf("operation interrupted: %w", err)
+	case errors.Is(ctx.Err(), context.DeadlineExceeded):
+		return fmt.Errorf("operation timed out, increase the timeouts of the resource: %w", err)
 	}
-	_, err = collector.Status(context.Background())
 	return err
 }
 
 func (c *CiliumClient) GetCurrentRelease() (*release.Release, error) {
-	// Use the default Helm driver (Kubernetes secret).
-	helmDriver := ""
-	actionConfig := action.Configuration{}
-	logger := func(format string, v ...interface{}) {}
-	if err := actionConfig.Init(c.client.RESTClientGetter, c.namespace, helmDriver, logger); err != nil {
+	// The action configuration uses the helm driver of the provider.
+	currentRelease, err := c.client.HelmActionConfig.Releases.Last(c.helm_release)
+	if err != nil {
+		return nil, err
+	}
+	return currentRelease, nil
+}
+
+// RollbackRelease rolls the release back to the given revision, like
+// `helm rollback`.
+func (c *CiliumClient) RollbackRelease(ctx context.Context, revision int) error {
+	tflog.Info(ctx, fmt.Sprintf("Rolling back the %s/%s release to revision %d", c.namespace, c.helm_release, revision))
+	rollback := action.NewRollback(c.client.HelmActionConfig)
+	rollback.Version = revision
+	return rollback.Run(c.helm_release)
+}
+
+// RollbackFailedUpgrade rolls back an upgrade, which failed or whose status
+// wait failed, to the revision which was current before it. It returns false
+// when the upgrade didn't create any revision, nothing being rolled back.
+func (c *CiliumClient) RollbackFailedUpgrade(ctx context.Context, previous *release.Release) (bool, error) {
+	rolledBack := false
+	err := c.HelmMutation(ctx, func() error {
+		currentRelease, err := c.GetCurrentRelease()
+		if err != nil {
+			return err
+		}
+		if currentRelease.Version == previous.Version {
+			return nil
+		}
+		rolledBack = true
+		return c.RollbackRelease(ctx, previous.Version)
+	})
+	return rolledBack, err
+}
+
+// newRegistryClient returns the client of the OCI registries. It is replaced
+// in tests.
+var newRegistryClient = func() (*registry.Client, error) {
+	return registry.NewClient(registry.ClientOptCredentialsFile(cli.New().RegistryConfig))
+}
+
+// LoadChart loads the Cilium chart like cilium-cli: a local chart (a directory
+// or a .tgz archive) when chartPath is set, otherwise the charts embedded in
+// cilium-cli for the default repository. The other ones are downloaded from a
+// Helm repository or from an OCI registry (e.g.
+// oci://quay.io/cilium/charts/cilium).
+//
+// With verify, the chart is checked against its provenance file with the keys
+// of keyring like `helm install --verify`: the embedded charts are not signed,
+// so the chart is downloaded instead.
+func LoadChart(chartPath, version, repository string, verify bool, keyring string) (*chart.Chart, error) {
+	if keyring == "" {
+		keyring = DefaultKeyring()
+	}
+	if chartPath != "" {
+		if verify {
+			if _, err := downloader.VerifyChart(chartPath, keyring); err != nil {
+				return nil, fmt.Errorf("verification of %s failed: %w", chartPath, err)
+			}
+		}
+		return loader.Load(chartPath)
+	}
+	version = strings.TrimPrefix(version, "v")
+	if repository == "" || repository == defaults.HelmRepository {
+		archive, err := charts.HelmFS.ReadFile(fmt.Sprintf("cilium-%s.tgz", version))
+		if err == nil && !verify {
+			return loader.LoadArchive(bytes.NewReader(archive))
+		}
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return nil, err
+		}
+		repository = defaults.HelmRepository
+	}
+	var options action.ChartPathOptions
+	name := "cilium"
+	if registry.IsOCI(repository) {
+		registryClient, err := newRegistryClient()
+		if err != nil {
+			return nil, err
+		}
+		// The registry client of the chart options is only set by actions.
+		options = action.NewInstall(&action.Configuration{RegistryClient: registryClient}).ChartPathOptions
+		name = repository
+	} else {
+		options.RepoURL = repository
+	}
+	options.Version = version
+	options.Verify = verify
+	options.Keyring = keyring
+	chartPath, err := options.LocateChart(name, cli.New())
+	if err != nil {
 		return nil, err
 	}
-	currentRelease, err := actionConfig.Releases.Last(c.helm_release)
+	return loader.Load(chartPath)
+}
+
+// DefaultKeyring returns the keyring used by helm to verify charts.
+func DefaultKeyring() string {
+	if v, ok := os.LookupEnv("GNUPGHOME"); ok {
+		return filepath.Join(v, "pubring.gpg")
+	}
+	return filepath.Join(homedir.HomeDir(), ".gnupg", "pubring.gpg")
+}
+
+// ChartDirectory writes the chart into a temporary directory: cilium-cli
+// only loads local charts from a directory. The returned function removes it.
+func ChartDirectory(ciliumChart *chart.Chart) (string, func(), error) {
+	dir, err := os.MkdirTemp("", ".chart.*")
+	if err != nil {
+		return "", func() {}, err
+	}
+	cleanup := func() { os.RemoveAll(dir) }
+	if err := chartutil.SaveDir(ciliumChart, dir); err != nil {
+		return "", cleanup, err
+	}
+	return filepath.Join(dir, ciliumChart.Name()), cleanup, nil
+}
+
+// DryRunUpgrade renders the upgrade of the Cilium release with the given
+// parameters and values, without applying it.
+func (c *CiliumClient) DryRunUpgrade(ctx context.Context, params install.Parameters, values map[string]interface{}) (*release.Release, error) {
+	ciliumChart, err := LoadChart(params.HelmChartDirectory, params.Version, params.HelmRepository, false, "")
 	if err != nil {
 		return nil, err
 	}
-	return currentRelease, nil
+	upgrade := action.NewUpgrade(c.client.HelmActionConfig)
+	upgrade.Namespace = params.Namespace
+	upgrade.ResetValues = params.HelmResetValues
+	upgrade.ReuseValues = params.HelmReuseValues
+	upgrade.ResetThenReuseValues = params.HelmResetThenReuseValues
+	upgrade.DryRun = true
+	upgrade.DryRunOption = "server"
+	return upgrade.RunWithContext(ctx, params.HelmReleaseName, ciliumChart, values)
 }
 
-func (c *CiliumClient) GetHelmValues() (string, error) {
-	helmDriver := ""
-	actionConfig := action.Configuration{}
-	logger := func(format string, v ...interface{}) {}
-	if err := actionConfig.Init(c.client.RESTClientGetter, c.namespace, helmDriver, logger); err != nil {
-		return "", err
+// UpgradeValues returns the values which an upgrade of the Cilium release with
+// the given parameters and values stores in the release, following the
+// reset, reuse and reset-then-reuse semantics of Helm.
+func (c *CiliumClient) UpgradeValues(params install.Parameters, values map[string]interface{}) (map[string]interface{}, error) {
+	releases := c.client.HelmActionConfig.Releases
+	current, err := releases.Last(c.helm_release)
+	if err != nil {
+		return nil, err
 	}
-	client := action.NewGetValues(&actionConfig)
+	// Helm upgrades from the deployed revision when the last one isn't.
+	if current.Info.Status != release.StatusDeployed {
+		deployed, err := releases.Deployed(c.helm_release)
+		if err == nil {
+			current = deployed
+		} else if !errors.Is(err, driver.ErrNoDeployedReleases) {
+			return nil, err
+		}
+	}
+
+	switch {
+	case params.HelmResetValues:
+		return values, nil
+	case params.HelmReuseValues, params.HelmResetThenReuseValues:
+		return chartutil.CoalesceTables(values, current.Config), nil
+	case len(values) == 0 && len(current.Config) > 0:
+		return current.Config, nil
+	}
+	return values, nil
+}
 
-	vals, err := client.Run(c.helm_release)
+// ManifestDiff summarises the objects added (+), changed (~) and removed (-)
+// between two rendered manifests, one object per line. The keys of the
+// changed ConfigMaps are listed.
+func ManifestDiff(from, to string) (string, error) {
+	fromObjects, err := manifestObjects(from)
 	if err != nil {
 		return "", err
 	}
-
-	yaml, err := yaml.Marshal(vals)
+	toObjects, err := manifestObjects(to)
 	if err != nil {
 		return "", err
 	}
-	return string(yaml), nil
+
+	keys := []string{}
+	for k := range fromObjects {
+		keys = append(keys, k)
+	}
+	for k := range toObjects {
+		if _, ok := fromObjects[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	lines := []string{}
+	for _, k := range keys {
+		fromObject, inFrom := fromObjects[k]
+		toObject, inTo := toObjects[k]
+		switch {
+		case !inFrom:
+			lines = append(lines, "+ "+k)
+		case !inTo:
+			lines = append(lines, "- "+k)
+		case !reflect.DeepEqual(fromObject, toObject):
+			line := "~ " + k
+			if changed := changedKeys(fromObject["data"], toObject["data"]); toObject["kind"] == "ConfigMap" && len(changed) > 0 {
+				line += ": " + strings.Join(changed, ", ")
+			}
+			lines = append(lines, line)
+		}
+	}
+	if len(lines) == 0 {
+		return "", nil
+	}
+	return strings.Join(lines, "\n") + "\n", nil
+}
+
+// manifestObjects indexes the objects of a manifest by
+// "apiVersion kind namespace/name".
+func manifestObjects(manifest string) (map[string]map[string]interface{}, error) {
+	objects := map[string]map[string]interface{}{}
+	for _, document := range releaseutil.SplitManifests(manifest) {
+		object := map[string]interface{}{}
+		if err := yaml.Unmarshal([]byte(document), &object); err != nil {
+			return nil, err
+		}
+		if len(object) == 0 {
+			continue
+		}
+		name, _ := GetValue(object, "metadata.name")
+		key := fmt.Sprintf("%v %v %v", object["apiVersion"], object["kind"], name)
+		if namespace, ok := GetValue(object, "metadata.namespace"); ok {
+			key = fmt.Sprintf("%v %v %v/%v", object["apiVersion"], object["kind"], namespace, name)
+		}
+		objects[key] = object
+	}
+	return objects, nil
+}
+
+// changedKeys lists the keys which differ between two maps.
+func changedKeys(from, to interface{}) []string {
+	fromMap, _ := from.(map[string]interface{})
+	toMap, _ := to.(map[string]interface{})
+	keys := []string{}
+	for k, v := range fromMap {
+		if w, ok := toMap[k]; !ok || !reflect.DeepEqual(v, w) {
+			keys = append(keys, k)
+		}
+	}
+	for k := range toMap {
+		if _, ok := fromMap[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// ownsRelease tells whether the revision is one of the Cilium release of c:
+// Helm finds the revisions by name only, and a shared helm_namespace stores the
+// releases of several namespaces.
+func (c *CiliumClient) ownsRelease(rel *release.Release) bool {
+	return rel.Name == c.helm_release && rel.Namespace == c.namespace &&
+		rel.Chart != nil && rel.Chart.Metadata != nil && rel.Chart.Metadata.Name == "cilium"
+}
+
+// RecoverPendingRelease unblocks the Cilium release when a previous operation
+// was killed while it was running: the pending revision is rolled back to the
+// last deployed one or, when there is none, marked as failed so that Helm
+// accepts to upgrade it. It must run with the lock of the release held.
+func (c *CiliumClient) RecoverPendingRelease(ctx context.Context) error {
+	currentRelease, err := c.GetCurrentRelease()
+	if err != nil {
+		return err
+	}
+	if !currentRelease.Info.Status.IsPending() {
+		return nil
+	}
+	if !c.ownsRelease(currentRelease) {
+		return fmt.Errorf("release %s/%s is %s and is not the Cilium release %s/%s, it has to be recovered by hand", currentRelease.Namespace, currentRelease.Name, currentRelease.Info.Status, c.namespace, c.helm_release)
+	}
+
+	deployed, err := c.client.HelmActionConfig.Releases.Deployed(c.helm_release)
+	if err == nil {
+		if !c.ownsRelease(deployed) {
+			return fmt.Errorf("the deployed revision %d of release %s/%s is not the Cilium release %s/%s, it has to be recovered by hand", deployed.Version, deployed.Namespace, deployed.Name, c.namespace, c.helm_release)
+		}
+		return c.RollbackRelease(ctx, deployed.Version)
+	}
+	if !errors.Is(err, driver.ErrNoDeployedReleases) {
+		return err
+	}
+
+	tflog.Info(ctx, fmt.Sprintf("Marking revision %d of the %s/%s release as failed", currentRelease.Version, c.namespace, c.helm_release))
+	currentRelease.SetStatus(release.StatusFailed, fmt.Sprintf("%s interrupted, recovered by Terraform", currentRelease.Info.Status))
+	return c.client.HelmActionConfig.Releases.Update(currentRelease)
 }
 
 func (c *CiliumClient) GetCA(ctx context.Context) (map[string]attr.Value, error) {
@@ -100,30 +801,248 @@ func (c *CiliumClient) GetCA(ctx context.Context) (map[string]attr.Value, error)
 	return ca, nil
 }
 
-func (c *CiliumClient) GetMetadata() (string, error) {
-	helmDriver := ""
-	actionConfig := action.Configuration{}
-	logger := func(format string, v ...interface{}) {}
-	if err := actionConfig.Init(c.client.RESTClientGetter, c.namespace, helmDriver, logger); err != nil {
-		return "", err
+// StateCA returns the CA to store in the state of the cilium resource: the
+// private key is left out unless the provider stores it.
+func (c *CiliumClient) StateCA(ca map[string]attr.Value) map[string]attr.Value {
+	if c.store_ca_key {
+		return ca
+	}
+	return map[string]attr.Value{
+		"crt": ca["crt"],
+		"key": types.StringNull(),
+	}
+}
+
+// ReleaseSnapshot is the Cilium release as seen by one operation: the release
+// is fetched once and the values, the version and the CA are derived from it.
+type ReleaseSnapshot struct {
+	client  *CiliumClient
+	Release *release.Release
+	// sensitive are the paths of the values redacted by Values and
+	// ValuesObject.
+	sensitive []string
+	ca        map[string]attr.Value
+}
+
+func (c *CiliumClient) GetReleaseSnapshot() (*ReleaseSnapshot, error) {
+	currentRelease, err := c.GetCurrentRelease()
+	if err != nil {
+		return nil, err
+	}
+	return &ReleaseSnapshot{client: c, Release: currentRelease}, nil
+}
+
+// Values returns the user supplied values (`helm get values`).
+func (s *ReleaseSnapshot) Values() (string, error) {
+	return releaseValues(RedactValues(s.Release.Config, s.sensitive))
+}
+
+// Redact hides the values of paths (e.g. the set_sensitive ones) from Values
+// and ValuesObject.
+func (s *ReleaseSnapshot) Redact(paths []string) {
+	s.sensitive = paths
+}
+
+// sensitiveValue replaces the redacted values, like the helm provider does.
+const sensitiveValue = "(sensitive value)"
+
+// RedactValues returns a copy of values where the values of paths are replaced
+// by sensitiveValue. values is not modified.
+func RedactValues(values map[string]interface{}, paths []string) map[string]interface{} {
+	for _, p := range paths {
+		values = redactValue(values, splitValuePath(p))
 	}
-	client := action.NewGetMetadata(&actionConfig)
+	return values
+}
 
-	vals, err := client.Run(c.helm_release)
+func redactValue(values map[string]interface{}, path []string) map[string]interface{} {
+	v, ok := values[path[0]]
+	if !ok {
+		return values
+	}
+	copied := make(map[string]interface{}, len(values))
+	for k, v := range values {
+		copied[k] = v
+	}
+	if len(path) == 1 {
+		copied[path[0]] = sensitiveValue
+		return copied
+	}
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		return values
+	}
+	copied[path[0]] = redactValue(m, path[1:])
+	return copied
+}
+
+// releaseValues encodes the user supplied values of a release like the
+// helm_values attribute.
+func releaseValues(config map[string]interface{}) (string, error) {
+	yaml, err := yaml.Marshal(config)
 	if err != nil {
 		return "", err
 	}
+	return string(yaml), nil
+}
+
+// ValuesObject returns the user supplied values as an object.
+func (s *ReleaseSnapshot) ValuesObject() (types.Dynamic, error) {
+	return valuesObject(RedactValues(s.Release.Config, s.sensitive))
+}
+
+// valuesObject converts the values of a release like the helm_values_object
+// attribute.
+func valuesObject(config map[string]interface{}) (types.Dynamic, error) {
+	v, err := ValueToTerraform(config)
+	if err != nil {
+		return types.DynamicNull(), err
+	}
+	return types.DynamicValue(v), nil
+}
+
+// SameValues tells whether two yaml documents of helm values are equal once
+// decoded. The numbers are compared like JSON ones: the planned values hold
+// the integers of --set while the releases are stored in JSON and decoded as
+// float64.
+func SameValues(a, b string) bool {
+	aValues, err := normalizeValues(a)
+	if err != nil {
+		return false
+	}
+	bValues, err := normalizeValues(b)
+	if err != nil {
+		return false
+	}
+	return reflect.DeepEqual(aValues, bValues)
+}
+
+// normalizeValues decodes a yaml document of helm values through JSON.
+func normalizeValues(document string) (interface{}, error) {
+	var values interface{}
+	if err := yaml.Unmarshal([]byte(document), &values); err != nil {
+		return nil, err
+	}
+	j, err := json.Marshal(values)
+	if err != nil {
+		return nil, err
+	}
+	var normalized interface{}
+	if err := json.Unmarshal(j, &normalized); err != nil {
+		return nil, err
+	}
+	return normalized, nil
+}
+
+// Version returns the Cilium version of the release.
+func (s *ReleaseSnapshot) Version() string {
+	if s.Release.Chart == nil || s.Release.Chart.Metadata == nil {
+		return ""
+	}
+	return s.Release.Chart.Metadata.AppVersion
+}
+
+// Revision returns the revision, the status and the date of the last
+// deployment of the release.
+func (s *ReleaseSnapshot) Revision() (types.Int64, types.String, types.String) {
+	lastDeployed := types.StringNull()
+	if !s.Release.Info.LastDeployed.IsZero() {
+		lastDeployed = types.StringValue(s.Release.Info.LastDeployed.Format(time.RFC3339))
+	}
+	return types.Int64Value(int64(s.Release.Version)), types.StringValue(s.Release.Info.Status.String()), lastDeployed
+}
 
-	return vals.AppVersion, nil
+// ClusterMeshConnected tells whether the release is connected to remote
+// clusters.
+func (s *ReleaseSnapshot) ClusterMeshConnected() bool {
+	clusters, _ := GetValue(s.Release.Config, "clustermesh.config.clusters")
+	l, ok := clusters.([]interface{})
+	return ok && len(l) > 0
 }
 
-func (c *CiliumClient) WaitClusterMesh() (err error) {
-	var params = clustermesh.Parameters{Writer: os.Stdout}
+// DatapathMode returns the datapath mode of cilium-cli (e.g. `tunnel` or
+// `aws-eni`) matching the values of the release and the defaults of its chart.
+// Cilium routes with tunnels when `routingMode` is not set.
+func (s *ReleaseSnapshot) DatapathMode() string {
+	values := s.Release.Config
+	if s.Release.Chart != nil {
+		if coalesced, err := chartutil.CoalesceValues(s.Release.Chart, s.Release.Config); err == nil {
+			values = coalesced
+		}
+	}
+	for _, mode := range []struct{ key, datapath string }{
+		{"eni.enabled", install.DatapathAwsENI},
+		{"gke.enabled", install.DatapathGKE},
+		{"azure.enabled", install.DatapathAzure},
+		{"aksbyocni.enabled", install.DatapathAKSBYOCNI},
+	} {
+		if enabled, _ := GetValue(values, mode.key); enabled == true {
+			return mode.datapath
+		}
+	}
+	if routingMode, _ := GetValue(values, "routingMode"); routingMode == "native" {
+		return install.DatapathNative
+	}
+	return install.DatapathTunnel
+}
+
+// ClusterIdentity returns the cluster name and the cluster ID of the values of
+// the release, null when they are not set.
+func (s *ReleaseSnapshot) ClusterIdentity() (types.String, types.Int64) {
+	name, id := types.StringNull(), types.Int64Null()
+	if v, ok := GetValue(s.Release.Config, "cluster.name"); ok && v != nil {
+		name = types.StringValue(fmt.Sprint(v))
+	}
+	if v, ok := GetValue(s.Release.Config, "cluster.id"); ok && v != nil {
+		if n, err := strconv.ParseInt(fmt.Sprint(v), 10, 64); err == nil {
+			id = types.Int64Value(n)
+		}
+	}
+	return name, id
+}
+
+// DetectPlatform returns the Kubernetes platform of the cluster, as detected by
+// cilium-cli (e.g. `kind` or `eks`). cilium-cli does not detect OpenShift: it
+// is recognised by its API groups.
+func (c *CiliumClient) DetectPlatform(ctx context.Context) string {
+	k8sClient, err := c.K8sClient()
+	if err != nil {
+		return k8s.KindUnknown.String()
+	}
+	kind := k8sClient.AutodetectFlavor(ctx).Kind
+	if kind == k8s.KindUnknown {
+		if groups, err := k8sClient.Clientset.Discovery().ServerGroups(); err == nil {
+			for _, group := range groups.Groups {
+				if group.Name == "config.openshift.io" {
+					return "openshift"
+				}
+			}
+		}
+	}
+	return strings.ToLower(kind.String())
+}
+
+// CA returns the cilium-ca secret, fetched on first use.
+func (s *ReleaseSnapshot) CA(ctx context.Context) (map[string]attr.Value, error) {
+	if s.ca == nil {
+		ca, err := s.client.GetCA(ctx)
+		if err != nil {
+			return ca, err
+		}
+		s.ca = ca
+	}
+	return s.ca, nil
+}
+
+func (c *CiliumClient) WaitClusterMesh(ctx context.Context, timeout time.Duration) (err error) {
+	var params = clustermesh.Parameters{Writer: c.LogWriter(ctx, "cilium_clustermesh")}
 	params.Namespace = c.namespace
+	params.ImpersonateAs = c.impersonate_as
+	params.ImpersonateGroups = c.impersonate_groups
 	params.Wait = true
-	params.WaitDuration = 2 * time.Minute
+	params.WaitDuration = timeout
 	cm := clustermesh.NewK8sClusterMesh(c.client, params)
-	if _, err := cm.Status(context.Background()); err != nil {
+	if _, err := cm.Status(ctx); err != nil {
 		return err
 	}
 	return nil
@@ -131,13 +1050,13 @@ func (c *CiliumClient) WaitClusterMesh() (err error) {
 
 func (c *CiliumClient) CheckDaemonsetStatus(ctx context.Context, namespace, daemonset string) error {
 	k8sClient := c.client
-	d, _ := k8sClient.GetDaemonSet(ctx, namespace, daemonset, metav1.GetOptions{})
-	if d == nil {
-		return nil
+	d, err := k8sClient.GetDaemonSet(ctx, namespace, daemonset, metav1.GetOptions{})
+	if err != nil {
+		return err
 	}
 
 	if d.Status.NumberReady != 0 {
-		return fmt.Errorf("replicas count is not zero")
+		return errDaemonsetRunning
 	}
 
 	return nil
@@ -167,3 +1086,218 @@ func ValueList(ctx context.Context, l types.List) []string {
 	}
 	return d
 }
+
+// MergeValues merges yaml documents of helm values like helm merges values
+// files: maps are merged recursively, any other value is replaced.
+func MergeValues(documents ...string) (map[string]interface{}, error) {
+	values := map[string]interface{}{}
+	for i, document := range documents {
+		v, err := chartutil.ReadValues([]byte(document))
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse values #%d: %w", i+1, err)
+		}
+		values = mergeMaps(values, v)
+	}
+	return values, nil
+}
+
+// mergeMaps is the merge helm applies between values files.
+func mergeMaps(a, b map[string]interface{}) map[string]interface{} {
+	out := make(map[string]interface{}, len(a))
+	for k, v := range a {
+		out[k] = v
+	}
+	for k, v := range b {
+		if v, ok := v.(map[string]interface{}); ok {
+			if bv, ok := out[k]; ok {
+				if bv, ok := bv.(map[string]interface{}); ok {
+					out[k] = mergeMaps(bv, v)
+					continue
+				}
+			}
+		}
+		out[k] = v
+	}
+	return out
+}
+
+// SetToValues parses `set` entries (key1=val1,key2=val2) with the parser helm
+// uses for --set.
+func SetToValues(set []string) (map[string]interface{}, error) {
+	values := map[string]interface{}{}
+	for _, s := range set {
+		if err := strvals.ParseInto(s, values); err != nil {
+			return nil, fmt.Errorf("unable to parse %q: %w", s, err)
+		}
+	}
+	return values, nil
+}
+
+// ValuesToYaml encodes helm values like the helm_values attribute.
+func ValuesToYaml(values map[string]interface{}) (string, error) {
+	if len(values) == 0 {
+		return "", nil
+	}
+	out, err := yaml.Marshal(values)
+	if err != nil {
+		return "", err
+	}
+	return string(out), nil
+}
+
+// GetValue returns the value found at a dotted path such as
+// hubble.relay.enabled. A dot inside a key is escaped with a backslash.
+func GetValue(values map[string]interface{}, key string) (interface{}, bool) {
+	var v interface{} = values
+	for _, k := range splitValuePath(key) {
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if v, ok = m[k]; !ok {
+			return nil, false
+		}
+	}
+	return v, true
+}
+
+func splitValuePath(key string) []string {
+	path := []string{}
+	var b strings.Builder
+	for i := 0; i < len(key); i++ {
+		switch {
+		case key[i] == '\\' && i+1 < len(key) && key[i+1] == '.':
+			b.WriteByte('.')
+			i++
+		case key[i] == '.':
+			path = append(path, b.String())
+			b.Reset()
+		default:
+			b.WriteByte(key[i])
+		}
+	}
+	return append(path, b.String())
+}
+
+// ValueToTerraform converts a helm value to a Terraform value: maps become
+// objects and lists become tuples.
+func ValueToTerraform(v interface{}) (attr.Value, error) {
+	switch v := v.(type) {
+	case nil:
+		// Terraform can't hold a dynamic value inside an object or a tuple.
+		return types.StringNull(), nil
+	case bool:
+		return types.BoolValue(v), nil
+	case string:
+		return types.StringValue(v), nil
+	case int:
+		return types.NumberValue(new(big.Float).SetInt64(int64(v))), nil
+	case int64:
+		return types.NumberValue(new(big.Float).SetInt64(v)), nil
+	case float64:
+		return types.NumberValue(big.NewFloat(v)), nil
+	case []interface{}:
+		elementTypes := make([]attr.Type, 0, len(v))
+		elements := make([]attr.Value, 0, len(v))
+		for _, e := range v {
+			element, err := ValueToTerraform(e)
+			if err != nil {
+				return nil, err
+			}
+			elementTypes = append(elementTypes, element.Type(context.Background()))
+			elements = append(elements, element)
+		}
+		value, diags := types.TupleValue(elementTypes, elements)
+		if diags.HasError() {
+			return nil, fmt.Errorf("unable to convert list: %v", diags)
+		}
+		return value, nil
+	case map[string]interface{}:
+		attributeTypes := make(map[string]attr.Type, len(v))
+		attributes := make(map[string]attr.Value, len(v))
+		for k, e := range v {
+			attribute, err := ValueToTerraform(e)
+			if err != nil {
+				return nil, err
+			}
+			attributeTypes[k] = attribute.Type(context.Background())
+			attributes[k] = attribute
+		}
+		value, diags := types.ObjectValue(attributeTypes, attributes)
+		if diags.HasError() {
+			return nil, fmt.Errorf("unable to convert map: %v", diags)
+		}
+		return value, nil
+	default:
+		return nil, fmt.Errorf("unsupported value type %T", v)
+	}
+}
+
+// TerraformToValue converts a Terraform value to a helm value, the reverse of
+// ValueToTerraform: whole numbers become integers.
+func TerraformToValue(ctx context.Context, v attr.Value) (interface{}, error) {
+	tfValue, err := v.ToTerraformValue(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return terraformToValue(tfValue)
+}
+
+func terraformToValue(v tftypes.Value) (interface{}, error) {
+	if !v.IsKnown() {
+		return nil, errors.New("the value is not known yet")
+	}
+	if v.IsNull() {
+		return nil, nil
+	}
+	typ := v.Type()
+	switch {
+	case typ.Is(tftypes.String):
+		var s string
+		err := v.As(&s)
+		return s, err
+	case typ.Is(tftypes.Bool):
+		var b bool
+		err := v.As(&b)
+		return b, err
+	case typ.Is(tftypes.Number):
+		n := new(big.Float)
+		if err := v.As(&n); err != nil {
+			return nil, err
+		}
+		if i, accuracy := n.Int64(); accuracy == big.Exact {
+			return i, nil
+		}
+		f, _ := n.Float64()
+		return f, nil
+	case typ.Is(tftypes.List{}), typ.Is(tftypes.Set{}), typ.Is(tftypes.Tuple{}):
+		var elements []tftypes.Value
+		if err := v.As(&elements); err != nil {
+			return nil, err
+		}
+		l := make([]interface{}, 0, len(elements))
+		for _, e := range elements {
+			element, err := terraformToValue(e)
+			if err != nil {
+				return nil, err
+			}
+			l = append(l, element)
+		}
+		return l, nil
+	case typ.Is(tftypes.Map{}), typ.Is(tftypes.Object{}):
+		var attributes map[string]tftypes.Value
+		if err := v.As(&attributes); err != nil {
+			return nil, err
+		}
+		m := make(map[string]interface{}, len(attributes))
+		for k, a := range attributes {
+			attribute, err := terraformToValue(a)
+			if err != nil {
+				return nil, err
+			}
+			m[k] = attribute
+		}
+		return m, nil
+	}
+	return nil, fmt.Errorf("unsupported type %s", typ)
+}
@@ -0,0 +1,1026 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package provider
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/cilium/cilium/cilium-cli/clustermesh"
+	"github.com/cilium/cilium/cilium-cli/hubble"
+	"github.com/cilium/cilium/cilium-cli/install"
+	"github.com/cilium/cilium/cilium-cli/k8s"
+	"github.com/cilium/cilium/cilium-cli/status"
+	"golang.org/x/crypto/openpgp" //nolint
+	"helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/chart/loader"
+	"helm.sh/helm/v3/pkg/chartutil"
+	"helm.sh/helm/v3/pkg/provenance"
+	"helm.sh/helm/v3/pkg/registry"
+	"helm.sh/helm/v3/pkg/release"
+	"helm.sh/helm/v3/pkg/storage/driver"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/hashicorp/terraform-plugin-framework/provider"
+	fwresource "github.com/hashicorp/terraform-plugin-framework/resource"
+	"github.com/hashicorp/terraform-plugin-framework/resource/schema/planmodifier"
+	"github.com/hashicorp/terraform-plugin-framework/tfsdk"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+	"github.com/hashicorp/terraform-plugin-framework/types/basetypes"
+	"github.com/hashicorp/terraform-plugin-go/tftypes"
+	"github.com/hashicorp/terraform-plugin-log/tflogtest"
+)
+
+// The installer merges the values file first, then the set entries.
+func TestMergeValuesLikeHelm(t *testing.T) {
+	values, err := MergeValues("ipam:\n  mode: cluster-pool\nhubble:\n  ui:\n    enabled: true\n", "hubble:\n  ui: null\n  relay:\n    enabled: true\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	set, err := SetToValues([]string{"ipam.mode=kubernetes,operator.replicas=1", `nodeSelector.kubernetes\.io/os=linux`})
+	if err != nil {
+		t.Fatal(err)
+	}
+	yaml, err := ValuesToYaml(mergeMaps(values, set))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "hubble:\n    relay:\n        enabled: true\n    ui: null\nipam:\n    mode: kubernetes\nnodeSelector:\n    kubernetes.io/os: linux\noperator:\n    replicas: 1\n"
+	if yaml != expected {
+		t.Errorf("got:\n%s\nexpected:\n%s", yaml, expected)
+	}
+
+	if v, ok := GetValue(set, `nodeSelector.kubernetes\.io/os`); !ok || v != "linux" {
+		t.Errorf("got %v, %v", v, ok)
+	}
+	if _, ok := GetValue(set, "operator.replicas.count"); ok {
+		t.Error("a scalar can't have children")
+	}
+	if _, err := SetToValues([]string{"ipam.mode"}); err == nil {
+		t.Error("set entries need a value")
+	}
+}
+
+func TestLogWriter(t *testing.T) {
+	var output bytes.Buffer
+	ctx := tflogtest.RootLogger(context.Background(), &output)
+	c := &CiliumClient{namespace: "kube-system", helm_release: "cilium"}
+
+	w := c.LogWriter(ctx, "cilium")
+	fmt.Fprintf(w, "🔮 Auto-detected Kubernetes kind: kind\n\n⌛ Waiting")
+	fmt.Fprintf(w, " for Cilium\r\n")
+
+	entries, err := tflogtest.MultilineJSONDecode(&output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("got %d entries: %v", len(entries), entries)
+	}
+	for i, message := range []string{"🔮 Auto-detected Kubernetes kind: kind", "⌛ Waiting for Cilium"} {
+		entry := entries[i]
+		if entry["@message"] != message || entry["@level"] != "info" || entry["resource_type"] != "cilium" || entry["namespace"] != "kube-system" || entry["helm_release"] != "cilium" {
+			t.Errorf("unexpected entry: %v", entry)
+		}
+	}
+}
+
+func TestWaitNamespaceDeleted(t *testing.T) {
+	srv := testFakeCluster(t, "kind-test", nil)
+	resp := testProviderConfigure(t, map[string]tftypes.Value{
+		"config_content": tftypes.NewValue(tftypes.String, testKubeConfig(srv.URL, "kind-test")),
+	}, provider.ConfigureProviderClientCapabilities{})
+	c := resp.ResourceData.(*CiliumClient)
+	if _, err := c.K8sClient(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := c.WaitNamespaceDeleted(context.Background(), "deleted"); err != nil {
+		t.Errorf("deleted namespace: %s", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	err := c.WaitNamespaceDeleted(ctx, "cilium-test")
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("returned after %s", elapsed)
+	}
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("got %v", err)
+	}
+	if msg := interrupted(ctx, err).Error(); msg != "operation timed out, increase the timeouts of the resource: namespace cilium-test is still terminating: context deadline exceeded" {
+		t.Errorf("got %q", msg)
+	}
+}
+
+func TestWaitNamespaceDeletedRetriesErrors(t *testing.T) {
+	var failed atomic.Bool
+	srv := testFakeCluster(t, "kind-test", map[string]http.HandlerFunc{
+		"/api/v1/namespaces/flaky": func(w http.ResponseWriter, r *http.Request) {
+			if failed.CompareAndSwap(false, true) {
+				testStatusError(http.StatusForbidden, "forbidden")(w, r)
+				return
+			}
+			http.NotFound(w, r)
+		},
+		"/api/v1/namespaces/forbidden": testStatusError(http.StatusForbidden, "forbidden"),
+	})
+	resp := testProviderConfigure(t, map[string]tftypes.Value{
+		"config_content": tftypes.NewValue(tftypes.String, testKubeConfig(srv.URL, "kind-test")),
+	}, provider.ConfigureProviderClientCapabilities{})
+	c := resp.ResourceData.(*CiliumClient)
+	if _, err := c.K8sClient(); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := c.WaitNamespaceDeleted(ctx, "flaky"); err != nil {
+		t.Errorf("flaky namespace: %s", err)
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	err := c.WaitNamespaceDeleted(ctx, "forbidden")
+	if !errors.Is(err, context.DeadlineExceeded) || !strings.Contains(err.Error(), "forbidden") {
+		t.Errorf("got %v", err)
+	}
+}
+
+// testHelmUpgrade upgrades the release of c like Helm does: the new revision
+// stays pending while the upgrade runs and its values are computed from the
+// last revision.
+func testHelmUpgrade(c *CiliumClient, values map[string]interface{}) error {
+	releases := c.client.HelmActionConfig.Releases
+	last, err := releases.Last(c.helm_release)
+	if err != nil {
+		return err
+	}
+	if last.Info.Status.IsPending() {
+		return errors.New("another operation (install/upgrade/rollback) is in progress")
+	}
+	next := *last
+	next.Version = last.Version + 1
+	next.Config = mergeMaps(last.Config, values)
+	next.Info = &release.Info{Status: release.StatusPendingUpgrade}
+	if err := releases.Create(&next); err != nil {
+		return err
+	}
+
+	time.Sleep(20 * time.Millisecond)
+
+	superseded := *last
+	superseded.Info = &release.Info{Status: release.StatusSuperseded}
+	if err := releases.Update(&superseded); err != nil {
+		return err
+	}
+	deployed := next
+	deployed.Info = &release.Info{Status: release.StatusDeployed}
+	return releases.Update(&deployed)
+}
+
+// Hubble and ClusterMesh upgrade the same release: Terraform applies them in
+// parallel while an upgrade started outside of Terraform is still pending.
+func TestHelmMutationConcurrentUpdates(t *testing.T) {
+	srv := testFakeCluster(t, "kind-test", nil)
+	hubbleResource := NewCiliumHubbleResource()
+	c := testResourceClient(t, hubbleResource, srv, map[string]tftypes.Value{
+		"helm_driver": tftypes.NewValue(tftypes.String, "memory"),
+	})
+	clustermeshResource := NewCiliumClusterMeshEnableResource()
+	clustermeshResource.(fwresource.ResourceWithConfigure).Configure(context.Background(), fwresource.ConfigureRequest{ProviderData: c}, &fwresource.ConfigureResponse{})
+
+	testCreateRelease(t, c, map[string]interface{}{"ipam": map[string]interface{}{"mode": "kubernetes"}})
+	pending := &release.Release{
+		Name:      c.helm_release,
+		Namespace: c.namespace,
+		Version:   2,
+		Info:      &release.Info{Status: release.StatusPendingUpgrade},
+		Config:    map[string]interface{}{"ipam": map[string]interface{}{"mode": "kubernetes"}},
+	}
+	if err := c.client.HelmActionConfig.Releases.Create(pending); err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		deployed := *pending
+		deployed.Info = &release.Info{Status: release.StatusDeployed}
+		c.client.HelmActionConfig.Releases.Update(&deployed)
+	}()
+
+	interval, enableHubble, enableClustermesh := helmRetryInterval, hubbleEnableWithHelm, clustermeshEnableWithHelm
+	defer func() {
+		helmRetryInterval, hubbleEnableWithHelm, clustermeshEnableWithHelm = interval, enableHubble, enableClustermesh
+	}()
+	helmRetryInterval = 10 * time.Millisecond
+	hubbleEnableWithHelm = func(ctx context.Context, k8sClient *k8s.Client, params hubble.Parameters) error {
+		return testHelmUpgrade(c, map[string]interface{}{"hubble": map[string]interface{}{"ui": map[string]interface{}{"enabled": params.UI}}})
+	}
+	clustermeshEnableWithHelm = func(ctx context.Context, k8sClient *k8s.Client, params clustermesh.Parameters) error {
+		return testHelmUpgrade(c, map[string]interface{}{"clustermesh": map[string]interface{}{"useAPIServer": true}})
+	}
+
+	var wg sync.WaitGroup
+	update := func(r fwresource.Resource, attributes map[string]tftypes.Value) {
+		defer wg.Done()
+		attributes["timeouts"] = testTimeouts("")
+		plan := testResourcePlan(t, r, attributes)
+		state := tfsdk.State{Schema: plan.Schema, Raw: plan.Raw}
+		resp := &fwresource.UpdateResponse{State: state}
+		r.Update(context.Background(), fwresource.UpdateRequest{Plan: plan, State: state}, resp)
+		if resp.Diagnostics.HasError() {
+			t.Errorf("unexpected diagnostics: %v", resp.Diagnostics)
+		}
+	}
+	wg.Add(2)
+	go update(hubbleResource, map[string]tftypes.Value{
+		"ui":    tftypes.NewValue(tftypes.Bool, true),
+		"relay": tftypes.NewValue(tftypes.Bool, true),
+		"id":    tftypes.NewValue(tftypes.String, "cilium-hubble"),
+	})
+	go update(clustermeshResource, map[string]tftypes.Value{
+		"service_type":         tftypes.NewValue(tftypes.String, "NodePort"),
+		"enable_kv_store_mesh": tftypes.NewValue(tftypes.Bool, false),
+		"wait":                 tftypes.NewValue(tftypes.Bool, false),
+		"id":                   tftypes.NewValue(tftypes.String, "ciliumclustermeshenable"),
+	})
+	wg.Wait()
+
+	last, err := c.client.HelmActionConfig.Releases.Last(c.helm_release)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if last.Version != 4 || last.Info.Status != release.StatusDeployed {
+		t.Errorf("got revision %d %s", last.Version, last.Info.Status)
+	}
+	for _, path := range []string{"ipam.mode", "hubble.ui.enabled", "clustermesh.useAPIServer"} {
+		if _, ok := GetValue(last.Config, path); !ok {
+			t.Errorf("%s is missing from %v", path, last.Config)
+		}
+	}
+}
+
+// A release left pending by an interrupted apply never recovers by itself.
+func TestHelmMutationPendingRelease(t *testing.T) {
+	interval := helmRetryInterval
+	defer func() { helmRetryInterval = interval }()
+	helmRetryInterval = time.Millisecond
+
+	c := &CiliumClient{namespace: "kube-system", helm_release: "cilium"}
+	attempts := 0
+	err := c.HelmMutation(context.Background(), func() error {
+		attempts++
+		return errors.New("another operation (install/upgrade/rollback) is in progress")
+	})
+	if attempts != helmPendingAttempts {
+		t.Errorf("got %d attempts", attempts)
+	}
+	if err == nil || !strings.Contains(err.Error(), "recover_pending") {
+		t.Errorf("got %v", err)
+	}
+}
+
+func TestRecoverPendingRelease(t *testing.T) {
+	cilium := &chart.Chart{Metadata: &chart.Metadata{Name: "cilium", Version: "1.17.3"}}
+	for name, tc := range map[string]struct {
+		releases []*release.Release
+		revision int
+		status   release.Status
+		config   map[string]interface{}
+		err      string
+	}{
+		"deployed": {
+			releases: []*release.Release{
+				{Version: 1, Chart: cilium, Info: &release.Info{Status: release.StatusDeployed}},
+			},
+			revision: 1,
+			status:   release.StatusDeployed,
+		},
+		"pending upgrade": {
+			releases: []*release.Release{
+				{Version: 1, Chart: cilium, Info: &release.Info{Status: release.StatusDeployed}, Config: map[string]interface{}{"debug": map[string]interface{}{"enabled": false}}},
+				{Version: 2, Chart: cilium, Info: &release.Info{Status: release.StatusPendingUpgrade}, Config: map[string]interface{}{"debug": map[string]interface{}{"enabled": true}}},
+			},
+			revision: 3,
+			status:   release.StatusDeployed,
+			config:   map[string]interface{}{"debug": map[string]interface{}{"enabled": false}},
+		},
+		"pending install": {
+			releases: []*release.Release{
+				{Version: 1, Chart: cilium, Info: &release.Info{Status: release.StatusPendingInstall}},
+			},
+			revision: 1,
+			status:   release.StatusFailed,
+		},
+		"other chart": {
+			releases: []*release.Release{
+				{Version: 1, Chart: &chart.Chart{Metadata: &chart.Metadata{Name: "nginx"}}, Info: &release.Info{Status: release.StatusPendingUpgrade}},
+			},
+			err: "is not the Cilium release kube-system/cilium",
+		},
+		"other namespace": {
+			releases: []*release.Release{
+				{Version: 1, Namespace: "cilium-system", Chart: cilium, Info: &release.Info{Status: release.StatusPendingUpgrade}},
+			},
+			err: "release cilium-system/cilium is pending-upgrade and is not the Cilium release kube-system/cilium",
+		},
+		"other namespace deployed": {
+			releases: []*release.Release{
+				{Version: 1, Namespace: "cilium-system", Chart: cilium, Info: &release.Info{Status: release.StatusDeployed}},
+				{Version: 2, Chart: cilium, Info: &release.Info{Status: release.StatusPendingUpgrade}},
+			},
+			err: "the deployed revision 1 of release cilium-system/cilium is not the Cilium release kube-system/cilium",
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			srv := testFakeCluster(t, "kind-test", nil)
+			c := testResourceClient(t, NewCiliumInstallResource(), srv, map[string]tftypes.Value{
+				"helm_driver": tftypes.NewValue(tftypes.String, "memory"),
+			})
+			shared := false
+			for _, rel := range tc.releases {
+				rel.Name = c.helm_release
+				if rel.Namespace == "" {
+					rel.Namespace = c.namespace
+				}
+				shared = shared || rel.Namespace != c.namespace
+				if err := c.client.HelmActionConfig.Releases.Create(rel); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if shared {
+				// Like a helm_namespace storing the releases of several namespaces.
+				c.client.HelmActionConfig.Releases.Driver.(*driver.Memory).SetNamespace("")
+			}
+
+			err := c.RecoverPendingRelease(context.Background())
+			if tc.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.err) {
+					t.Fatalf("got %v, expected %q", err, tc.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			last, err := c.GetCurrentRelease()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if last.Version != tc.revision || last.Info.Status != tc.status {
+				t.Errorf("got revision %d %s, expected %d %s", last.Version, last.Info.Status, tc.revision, tc.status)
+			}
+			if tc.config != nil && fmt.Sprint(last.Config) != fmt.Sprint(tc.config) {
+				t.Errorf("got values %v, expected %v", last.Config, tc.config)
+			}
+		})
+	}
+}
+
+func TestRollbackFailedUpgrade(t *testing.T) {
+	cilium := &chart.Chart{Metadata: &chart.Metadata{Name: "cilium", Version: "1.17.3"}}
+	for name, tc := range map[string]struct {
+		releases   []*release.Release
+		rolledBack bool
+		revision   int
+	}{
+		"failed upgrade": {
+			releases: []*release.Release{
+				{Version: 1, Chart: cilium, Info: &release.Info{Status: release.StatusSuperseded}, Config: map[string]interface{}{"debug": map[string]interface{}{"enabled": false}}},
+				{Version: 2, Chart: cilium, Info: &release.Info{Status: release.StatusFailed}, Config: map[string]interface{}{"debug": map[string]interface{}{"enabled": true}}},
+			},
+			rolledBack: true,
+			revision:   3,
+		},
+		"no revision": {
+			releases: []*release.Release{
+				{Version: 1, Chart: cilium, Info: &release.Info{Status: release.StatusDeployed}, Config: map[string]interface{}{"debug": map[string]interface{}{"enabled": false}}},
+			},
+			revision: 1,
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			srv := testFakeCluster(t, "kind-test", nil)
+			c := testResourceClient(t, NewCiliumInstallResource(), srv, map[string]tftypes.Value{
+				"helm_driver": tftypes.NewValue(tftypes.String, "memory"),
+			})
+			shared := false
+			for _, rel := range tc.releases {
+				rel.Name = c.helm_release
+				if rel.Namespace == "" {
+					rel.Namespace = c.namespace
+				}
+				shared = shared || rel.Namespace != c.namespace
+				if err := c.client.HelmActionConfig.Releases.Create(rel); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if shared {
+				// Like a helm_namespace storing the releases of several namespaces.
+				c.client.HelmActionConfig.Releases.Driver.(*driver.Memory).SetNamespace("")
+			}
+
+			rolledBack, err := c.RollbackFailedUpgrade(context.Background(), tc.releases[0])
+			if err != nil {
+				t.Fatal(err)
+			}
+			if rolledBack != tc.rolledBack {
+				t.Errorf("rolled back: got %t, expected %t", rolledBack, tc.rolledBack)
+			}
+			last, err := c.GetCurrentRelease()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if last.Version != tc.revision || last.Info.Status != release.StatusDeployed {
+				t.Errorf("got revision %d %s, expected %d deployed", last.Version, last.Info.Status, tc.revision)
+			}
+			if fmt.Sprint(last.Config) != fmt.Sprint(tc.releases[0].Config) {
+				t.Errorf("got values %v, expected %v", last.Config, tc.releases[0].Config)
+			}
+		})
+	}
+}
+
+func TestManifestDiff(t *testing.T) {
+	from := `---
+# Source: cilium/templates/cilium-configmap.yaml
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: cilium-config
+  namespace: kube-system
+data:
+  debug: "false"
+  enable-hubble: "false"
+  ipam: kubernetes
+---
+apiVersion: apps/v1
+kind: DaemonSet
+metadata:
+  name: cilium
+  namespace: kube-system
+spec:
+  template:
+    spec:
+      containers:
+      - image: quay.io/cilium/cilium:v1.17.3
+---
+apiVersion: rbac.authorization.k8s.io/v1
+kind: ClusterRole
+metadata:
+  name: cilium-operator
+`
+	to := `---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: cilium-config
+  namespace: kube-system
+data:
+  debug: "false"
+  enable-hubble: "true"
+  hubble-listen-address: ":4244"
+---
+apiVersion: apps/v1
+kind: DaemonSet
+metadata:
+  name: cilium
+  namespace: kube-system
+spec:
+  template:
+    spec:
+      containers:
+      - image: quay.io/cilium/cilium:v1.17.3
+---
+apiVersion: v1
+kind: Service
+metadata:
+  name: hubble-peer
+  namespace: kube-system
+`
+	diff, err := ManifestDiff(from, to)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `- rbac.authorization.k8s.io/v1 ClusterRole cilium-operator
+~ v1 ConfigMap kube-system/cilium-config: enable-hubble, hubble-listen-address, ipam
++ v1 Service kube-system/hubble-peer
+`
+	if diff != expected {
+		t.Errorf("got:\n%s\nexpected:\n%s", diff, expected)
+	}
+
+	if diff, err := ManifestDiff(from, from); err != nil || diff != "" {
+		t.Errorf("got %q, %v", diff, err)
+	}
+}
+
+func TestUpgradeValues(t *testing.T) {
+	current := map[string]interface{}{"ipam": map[string]interface{}{"mode": "kubernetes"}, "debug": map[string]interface{}{"enabled": true}}
+	for name, tc := range map[string]struct {
+		params   install.Parameters
+		values   map[string]interface{}
+		expected string
+	}{
+		"default without values": {
+			values:   map[string]interface{}{},
+			expected: "debug:\n    enabled: true\nipam:\n    mode: kubernetes\n",
+		},
+		"default with values": {
+			values:   map[string]interface{}{"debug": map[string]interface{}{"enabled": false}},
+			expected: "debug:\n    enabled: false\n",
+		},
+		"reset": {
+			params:   install.Parameters{HelmResetValues: true},
+			values:   map[string]interface{}{},
+			expected: "{}\n",
+		},
+		"reuse": {
+			params:   install.Parameters{HelmReuseValues: true},
+			values:   map[string]interface{}{"debug": map[string]interface{}{"enabled": false}},
+			expected: "debug:\n    enabled: false\nipam:\n    mode: kubernetes\n",
+		},
+		"reset then reuse": {
+			params:   install.Parameters{HelmResetThenReuseValues: true},
+			values:   map[string]interface{}{"hubble": map[string]interface{}{"enabled": true}},
+			expected: "debug:\n    enabled: true\nhubble:\n    enabled: true\nipam:\n    mode: kubernetes\n",
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			srv := testFakeCluster(t, "kind-test", nil)
+			c := testResourceClient(t, NewCiliumInstallResource(), srv, map[string]tftypes.Value{
+				"helm_driver": tftypes.NewValue(tftypes.String, "memory"),
+			})
+			testCreateRelease(t, c, current)
+
+			values, err := c.UpgradeValues(tc.params, tc.values)
+			if err != nil {
+				t.Fatal(err)
+			}
+			yaml, err := releaseValues(values)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if yaml != tc.expected {
+				t.Errorf("got:\n%s\nexpected:\n%s", yaml, tc.expected)
+			}
+		})
+	}
+}
+
+func TestSameValues(t *testing.T) {
+	if !SameValues("b: 1\na:\n  c: true\n", "a: {c: true}\nb: 1\n") {
+		t.Error("the values should be the same")
+	}
+	if SameValues("a: 1\n", "a: \"1\"\n") {
+		t.Error("the values should differ")
+	}
+	// The applied values are decoded from JSON as float64.
+	if !SameValues("clustermesh:\n  maxConnectedClusters: 511\nbpf:\n  natMax: 1048576\n", "clustermesh:\n  maxConnectedClusters: 511\nbpf:\n  natMax: 1.048576e+06\n") {
+		t.Error("the numbers should be the same")
+	}
+}
+
+func TestTerraformToValue(t *testing.T) {
+	values := map[string]interface{}{
+		"debug":    map[string]interface{}{"enabled": true},
+		"replicas": int64(2),
+		"ratio":    0.5,
+		"name":     "kind",
+		"list":     []interface{}{"a", int64(1)},
+	}
+	tfValue, err := ValueToTerraform(values)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v, err := TerraformToValue(context.Background(), types.DynamicValue(tfValue))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(v) != fmt.Sprint(values) {
+		t.Errorf("got %v, expected %v", v, values)
+	}
+
+	if _, err := TerraformToValue(context.Background(), types.StringUnknown()); err == nil {
+		t.Error("expected an error for an unknown value")
+	}
+}
+
+// testOCIRegistry serves the chart archive, with its provenance file when prov
+// is not nil, as <host>/charts/cilium:<version> like an OCI registry.
+func testOCIRegistry(t *testing.T, archive, prov []byte, version string) string {
+	t.Helper()
+	blobs := map[string][]byte{}
+	blob := func(content []byte) map[string]interface{} {
+		digest := fmt.Sprintf("sha256:%x", sha256.Sum256(content))
+		blobs[digest] = content
+		return map[string]interface{}{"digest": digest, "size": len(content)}
+	}
+	config := blob([]byte(fmt.Sprintf(`{"name":"cilium","version":%q,"apiVersion":"v2"}`, version)))
+	config["mediaType"] = registry.ConfigMediaType
+	layer := blob(archive)
+	layer["mediaType"] = registry.ChartLayerMediaType
+	layers := []interface{}{layer}
+	if prov != nil {
+		provLayer := blob(prov)
+		provLayer["mediaType"] = registry.ProvLayerMediaType
+		layers = append(layers, provLayer)
+	}
+	const manifestMediaType = "application/vnd.oci.image.manifest.v1+json"
+	manifest, err := json.Marshal(map[string]interface{}{
+		"schemaVersion": 2,
+		"mediaType":     manifestMediaType,
+		"config":        config,
+		"layers":        layers,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	manifestDigest := fmt.Sprintf("sha256:%x", sha256.Sum256(manifest))
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		serve := func(content []byte, mediaType, digest string) {
+			w.Header().Set("Content-Type", mediaType)
+			w.Header().Set("Docker-Content-Digest", digest)
+			w.Header().Set("Content-Length", fmt.Sprint(len(content)))
+			if r.Method != http.MethodHead {
+				w.Write(content)
+			}
+		}
+		switch p := r.URL.Path; {
+		case p == "/v2/":
+			w.WriteHeader(http.StatusOK)
+		case p == "/v2/charts/cilium/tags/list":
+			fmt.Fprintf(w, `{"name":"charts/cilium","tags":[%q]}`, version)
+		case p == "/v2/charts/cilium/manifests/"+version || p == "/v2/charts/cilium/manifests/"+manifestDigest:
+			serve(manifest, manifestMediaType, manifestDigest)
+		case strings.HasPrefix(p, "/v2/charts/cilium/blobs/") && blobs[strings.TrimPrefix(p, "/v2/charts/cilium/blobs/")] != nil:
+			digest := strings.TrimPrefix(p, "/v2/charts/cilium/blobs/")
+			serve(blobs[digest], "application/octet-stream", digest)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	defaultRegistryClient := newRegistryClient
+	newRegistryClient = func() (*registry.Client, error) {
+		return registry.NewClient(registry.ClientOptPlainHTTP(), registry.ClientOptWriter(io.Discard))
+	}
+	t.Cleanup(func() { newRegistryClient = defaultRegistryClient })
+	t.Setenv("HELM_REPOSITORY_CACHE", t.TempDir())
+	return strings.TrimPrefix(server.URL, "http://")
+}
+
+// The chart is loaded from a directory, a .tgz archive or an OCI registry.
+func TestLoadChart(t *testing.T) {
+	archivePath := testChartArchive(t, t.TempDir(), "1.18.0")
+	archive, err := os.ReadFile(archivePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	host := testOCIRegistry(t, archive, nil, "1.18.0")
+
+	for name, load := range map[string]func() (*chart.Chart, error){
+		"directory": func() (*chart.Chart, error) { return LoadChart("testdata/chart/cilium", "", "", false, "") },
+		"archive":   func() (*chart.Chart, error) { return LoadChart(archivePath, "", "", false, "") },
+		"oci": func() (*chart.Chart, error) {
+			return LoadChart("", "v1.18.0", "oci://"+host+"/charts/cilium", false, "")
+		},
+	} {
+		ciliumChart, err := load()
+		if err != nil {
+			t.Errorf("%s: %s", name, err)
+			continue
+		}
+		if ciliumChart.Name() != "cilium" || ciliumChart.Metadata.Version != "1.18.0" || len(ciliumChart.Templates) != 1 {
+			t.Errorf("%s: unexpected chart %s %s with %d templates", name, ciliumChart.Name(), ciliumChart.Metadata.Version, len(ciliumChart.Templates))
+		}
+	}
+
+	if _, err := LoadChart("testdata/chart/missing", "", "", false, ""); err == nil {
+		t.Error("expected an error for a missing chart")
+	}
+	if _, err := LoadChart("", "1.18.1", "oci://"+host+"/charts/cilium", false, ""); err == nil {
+		t.Error("expected an error for a missing version")
+	}
+}
+
+// testSignChart writes the provenance file of the chart archive, signed with a
+// throwaway key, and returns the keyring of its public key.
+func testSignChart(t *testing.T, archivePath string) string {
+	t.Helper()
+	entity, err := openpgp.NewEntity("cilium-test", "", "cilium-test@example.com", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signatory := provenance.Signatory{Entity: entity}
+	prov, err := signatory.ClearSign(archivePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(archivePath+".prov", []byte(prov), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	keyring, err := os.Create(filepath.Join(t.TempDir(), "pubring.gpg"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer keyring.Close()
+	if err := entity.Serialize(keyring); err != nil {
+		t.Fatal(err)
+	}
+	return keyring.Name()
+}
+
+// testChartArchive packages the chart fixture, with the given version, into
+// dir.
+func testChartArchive(t *testing.T, dir, version string) string {
+	t.Helper()
+	fixture, err := loader.Load("testdata/chart/cilium")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fixture.Metadata.Version = version
+	archivePath, err := chartutil.Save(fixture, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return archivePath
+}
+
+// The chart is only loaded when it matches its provenance file.
+func TestLoadChartVerify(t *testing.T) {
+	archivePath := testChartArchive(t, t.TempDir(), "1.18.0")
+	keyring := testSignChart(t, archivePath)
+	otherKeyring := testSignChart(t, testChartArchive(t, t.TempDir(), "1.18.0"))
+
+	if _, err := LoadChart(archivePath, "", "", true, keyring); err != nil {
+		t.Errorf("signed archive: %s", err)
+	}
+	if _, err := LoadChart(archivePath, "", "", true, otherKeyring); err == nil {
+		t.Error("expected an error for a signature of an unknown key")
+	}
+	if _, err := LoadChart("testdata/chart/cilium", "", "", true, keyring); err == nil {
+		t.Error("expected an error for a chart directory")
+	}
+	if _, err := LoadChart(testChartArchive(t, t.TempDir(), "1.18.0"), "", "", true, keyring); err == nil {
+		t.Error("expected an error for an archive without provenance file")
+	}
+
+	// Another chart with the provenance file of the signed one.
+	tampered := testChartArchive(t, t.TempDir(), "1.18.0")
+	fixture, err := loader.Load(tampered)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fixture.Metadata.Description = "Tampered chart"
+	if tampered, err = chartutil.Save(fixture, filepath.Dir(tampered)); err != nil {
+		t.Fatal(err)
+	}
+	prov, err := os.ReadFile(archivePath + ".prov")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(tampered+".prov", prov, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadChart(tampered, "", "", true, keyring); err == nil || !strings.Contains(err.Error(), "sha256 sum does not match") {
+		t.Errorf("expected a digest mismatch error, got %v", err)
+	}
+
+	archive, err := os.ReadFile(archivePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	host := testOCIRegistry(t, archive, prov, "1.18.0")
+	if _, err := LoadChart("", "1.18.0", "oci://"+host+"/charts/cilium", true, keyring); err != nil {
+		t.Errorf("signed OCI chart: %s", err)
+	}
+	if _, err := LoadChart("", "1.18.0", "oci://"+host+"/charts/cilium", true, otherKeyring); err == nil {
+		t.Error("expected an error for an OCI chart signed by an unknown key")
+	}
+	host = testOCIRegistry(t, archive, nil, "1.18.0")
+	if _, err := LoadChart("", "1.18.0", "oci://"+host+"/charts/cilium", true, keyring); err == nil {
+		t.Error("expected an error for an OCI chart without provenance file")
+	}
+}
+
+func TestForRelease(t *testing.T) {
+	srv := testFakeCluster(t, "kind-test", nil)
+	c := testResourceClient(t, NewCiliumInstallResource(), srv, map[string]tftypes.Value{
+		"helm_driver":    tftypes.NewValue(tftypes.String, "memory"),
+		"helm_namespace": tftypes.NewValue(tftypes.String, "helm-releases"),
+	})
+	if got := c.ForRelease(types.StringNull(), types.StringValue(c.helm_release)); got != c {
+		t.Error("the release of the provider should use the client of the provider")
+	}
+	other := c.ForRelease(types.StringValue("cilium-system"), types.StringValue("cilium-2"))
+	if other.namespace != "cilium-system" || other.helm_release != "cilium-2" {
+		t.Fatalf("got release %s/%s", other.namespace, other.helm_release)
+	}
+	if got := c.ForRelease(types.StringValue("cilium-system"), types.StringValue("cilium-2")); got != other {
+		t.Error("the client of a release should be reused")
+	}
+	// Helm stores both releases in helm_namespace: the Kubernetes client is shared.
+	k8sClient, err := other.K8sClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if k8sClient != c.client {
+		t.Error("the Kubernetes client should be shared")
+	}
+
+	unlock := other.LockRelease(other.namespace, other.helm_release)
+	locked := make(chan struct{})
+	go func() {
+		c.LockRelease("cilium-system", "cilium-2")()
+		close(locked)
+	}()
+	select {
+	case <-locked:
+		t.Fatal("the release should be locked by the client of the release")
+	case <-time.After(50 * time.Millisecond):
+	}
+	unlock()
+	<-locked
+}
+
+// Two aliases of the provider managing the same release share its lock.
+func TestLockReleaseAliases(t *testing.T) {
+	srv := testFakeCluster(t, "kind-test", nil)
+	otherSrv := testFakeCluster(t, "kind-other", nil)
+	var clients []*CiliumClient
+	for _, server := range []*httptest.Server{srv, srv, otherSrv} {
+		c := testResourceClient(t, NewCiliumInstallResource(), server, map[string]tftypes.Value{
+			"helm_driver": tftypes.NewValue(tftypes.String, "memory"),
+		})
+		clients = append(clients, c)
+	}
+
+	unlock := clients[0].LockRelease("kube-system", "cilium")
+	// The release of another cluster is not locked.
+	clients[2].LockRelease("kube-system", "cilium")()
+	locked := make(chan struct{})
+	go func() {
+		clients[1].LockRelease("kube-system", "cilium")()
+		close(locked)
+	}()
+	select {
+	case <-locked:
+		t.Fatal("the release should be locked by the other alias")
+	case <-time.After(50 * time.Millisecond):
+	}
+	unlock()
+	<-locked
+}
+
+func TestReleaseAttributesRequiresReplace(t *testing.T) {
+	c := &CiliumClient{namespace: "kube-system", helm_release: "cilium"}
+	namespace, _ := releaseAttributes(func() *CiliumClient { return c })
+	for _, tc := range []struct {
+		state, plan types.String
+		replace     bool
+	}{
+		{types.StringNull(), types.StringValue("kube-system"), false},
+		{types.StringValue("kube-system"), types.StringNull(), false},
+		{types.StringNull(), types.StringValue("cilium-system"), true},
+		{types.StringValue("cilium-system"), types.StringNull(), true},
+	} {
+		req := planmodifier.StringRequest{
+			State:      tfsdk.State{Raw: tftypes.NewValue(tftypes.Object{}, map[string]tftypes.Value{})},
+			Plan:       tfsdk.Plan{Raw: tftypes.NewValue(tftypes.Object{}, map[string]tftypes.Value{})},
+			StateValue: tc.state,
+			PlanValue:  tc.plan,
+		}
+		resp := &planmodifier.StringResponse{PlanValue: tc.plan}
+		namespace.PlanModifiers[0].PlanModifyString(context.Background(), req, resp)
+		if resp.RequiresReplace != tc.replace {
+			t.Errorf("%s -> %s: got requires replace %t", tc.state, tc.plan, resp.RequiresReplace)
+		}
+	}
+}
+
+func TestDetectPlatform(t *testing.T) {
+	kind := testFakeCluster(t, "kind-test", nil)
+	if platform := testResourceClient(t, NewCiliumInstallResource(), kind, nil).DetectPlatform(context.Background()); platform != "kind" {
+		t.Errorf("got platform %q", platform)
+	}
+
+	openshift := testFakeCluster(t, "openshift", map[string]http.HandlerFunc{
+		"/api":  testObject(metav1.APIVersions{Versions: []string{"v1"}}),
+		"/apis": testObject(metav1.APIGroupList{Groups: []metav1.APIGroup{{Name: "config.openshift.io"}}}),
+	})
+	c := testResourceClient(t, NewCiliumInstallResource(), openshift, map[string]tftypes.Value{
+		"config_content": tftypes.NewValue(tftypes.String, testKubeConfig(openshift.URL, "openshift")),
+	})
+	if platform := c.DetectPlatform(context.Background()); platform != "openshift" {
+		t.Errorf("got platform %q", platform)
+	}
+}
+
+func TestDatapathMode(t *testing.T) {
+	for _, tc := range []struct {
+		defaults, config map[string]interface{}
+		mode             string
+	}{
+		{nil, nil, "tunnel"},
+		{nil, map[string]interface{}{"routingMode": "native"}, "native"},
+		{map[string]interface{}{"routingMode": "native"}, nil, "native"},
+		{map[string]interface{}{"routingMode": "native"}, map[string]interface{}{"routingMode": "tunnel"}, "tunnel"},
+		{nil, map[string]interface{}{"routingMode": "native", "eni": map[string]interface{}{"enabled": true}}, "aws-eni"},
+		{map[string]interface{}{"gke": map[string]interface{}{"enabled": false}}, map[string]interface{}{"gke": map[string]interface{}{"enabled": true}}, "gke"},
+	} {
+		snapshot := &ReleaseSnapshot{Release: &release.Release{
+			Chart:  &chart.Chart{Metadata: &chart.Metadata{Name: "cilium"}, Values: tc.defaults},
+			Config: tc.config,
+		}}
+		if mode := snapshot.DatapathMode(); mode != tc.mode {
+			t.Errorf("%v %v: got %q, want %q", tc.defaults, tc.config, mode, tc.mode)
+		}
+	}
+}
+
+func TestStatusValue(t *testing.T) {
+	if v := StatusValue(nil); !v.IsNull() {
+		t.Errorf("got %s", v)
+	}
+	s := &status.Status{
+		ImageCount: status.MapMapCount{"cilium": {"quay.io/cilium/cilium:v1.17.3": 2}},
+		PodState: status.PodStateMap{
+			"cilium":          {Type: "DaemonSet", Desired: 3, Ready: 2, Available: 2, Unavailable: 1},
+			"cilium-operator": {Type: "Deployment", Desired: 1, Ready: 1, Available: 1},
+		},
+		PodsCount: status.PodsCount{All: 10, ByCilium: 7},
+		Errors: status.ErrorCountMapMap{"cilium": {
+			"cilium":       {Errors: []error{errors.New("1 pods of DaemonSet cilium are not ready")}},
+			"cilium-x2b9z": {Errors: []error{errors.New("pod is pending")}, Warnings: []error{errors.New("ignored")}},
+		}},
+		CollectionErrors: []error{errors.New("unable to list pods")},
+	}
+
+	var data struct {
+		Components map[string]struct {
+			Desired     int64 `tfsdk:"desired"`
+			Ready       int64 `tfsdk:"ready"`
+			Available   int64 `tfsdk:"available"`
+			Unavailable int64 `tfsdk:"unavailable"`
+		} `tfsdk:"components"`
+		Images        map[string][]string `tfsdk:"images"`
+		Pods          int64               `tfsdk:"pods"`
+		UnmanagedPods int64               `tfsdk:"unmanaged_pods"`
+		Errors        []string            `tfsdk:"errors"`
+	}
+	if diags := StatusValue(s).As(context.Background(), &data, basetypes.ObjectAsOptions{}); diags.HasError() {
+		t.Fatal(diags)
+	}
+	if agent := data.Components["cilium"]; agent.Desired != 3 || agent.Ready != 2 || agent.Unavailable != 1 {
+		t.Errorf("got agent %+v", agent)
+	}
+	if images := data.Images["cilium"]; len(images) != 1 || images[0] != "quay.io/cilium/cilium:v1.17.3" {
+		t.Errorf("got images %v", images)
+	}
+	if data.Pods != 10 || data.UnmanagedPods != 3 {
+		t.Errorf("got %d pods, %d unmanaged", data.Pods, data.UnmanagedPods)
+	}
+	expected := []string{"cilium: 1 pods of DaemonSet cilium are not ready", "cilium: cilium-x2b9z: pod is pending", "unable to list pods"}
+	if fmt.Sprint(data.Errors) != fmt.Sprint(expected) {
+		t.Errorf("got errors %q", data.Errors)
+	}
+
+	summary := StatusSummary(s)
+	for _, line := range append([]string{"DaemonSet cilium: desired 3, ready 2, available 2, unavailable 1"}, expected...) {
+		if !strings.Contains(summary, "\n"+line) {
+			t.Errorf("%q is missing from the summary:%s", line, summary)
+		}
+	}
+}
+
+// A failed wait returns the last status collected.
+func TestWaitStatus(t *testing.T) {
+	srv := testFakeCluster(t, "kind-test", nil)
+	c := testResourceClient(t, NewCiliumInstallResource(), srv, nil)
+	collected, err := c.Wait(context.Background(), 100*time.Millisecond)
+	if err == nil {
+		t.Fatal("expected the wait to fail")
+	}
+	if collected == nil || !strings.Contains(StatusSummary(collected), "cilium") {
+		t.Errorf("got summary %q", StatusSummary(collected))
+	}
+}
@@ -5,11 +5,13 @@ package provider
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"os"
 
+	"github.com/cilium/cilium/cilium-cli/defaults"
 	"github.com/cilium/cilium/cilium-cli/hubble"
 
+	"github.com/hashicorp/terraform-plugin-framework-timeouts/resource/timeouts"
 	"github.com/hashicorp/terraform-plugin-framework/path"
 	"github.com/hashicorp/terraform-plugin-framework/resource"
 	"github.com/hashicorp/terraform-plugin-framework/resource/schema"
@@ -20,6 +22,9 @@ import (
 	"github.com/hashicorp/terraform-plugin-log/tflog"
 )
 
+// hubbleEnableWithHelm is replaced in tests.
+var hubbleEnableWithHelm = hubble.EnableWithHelm
+
 // Ensure provider defined types fully satisfy framework interfaces.
 var _ resource.Resource = &CiliumHubbleResource{}
 var _ resource.ResourceWithImportState = &CiliumHubbleResource{}
@@ -35,9 +40,12 @@ type CiliumHubbleResource struct {
 
 // CiliumHubbleResourceModel describes the resource data model.
 type CiliumHubbleResourceModel struct {
-	Relay types.Bool   `tfsdk:"relay"`
-	UI    types.Bool   `tfsdk:"ui"`
-	Id    types.String `tfsdk:"id"`
+	Relay       types.Bool     `tfsdk:"relay"`
+	UI          types.Bool     `tfsdk:"ui"`
+	Namespace   types.String   `tfsdk:"namespace"`
+	HelmRelease types.String   `tfsdk:"helm_release"`
+	Id          types.String   `tfsdk:"id"`
+	Timeouts    timeouts.Value `tfsdk:"timeouts"`
 }
 
 func (r *CiliumHubbleResource) Metadata(ctx context.Context, req resource.MetadataRequest, resp *resource.MetadataResponse) {
@@ -45,11 +53,14 @@ func (r *CiliumHubbleResource) Metadata(ctx context.Context, req resource.Metada
 }
 
 func (r *CiliumHubbleResource) Schema(ctx context.Context, req resource.SchemaRequest, resp *resource.SchemaResponse) {
+	namespace, helmRelease := releaseAttributes(func() *CiliumClient { return r.client })
 	resp.Schema = schema.Schema{
 		// This description is used by the documentation generator and the language server.
 		MarkdownDescription: "Hubble resource for Cilium. This is equivalent to cilium cli: `cilium hubble`: It manages cilium hubble",
 
 		Attributes: map[string]schema.Attribute{
+			"namespace":    namespace,
+			"helm_release": helmRelease,
 			"ui": schema.BoolAttribute{
 				MarkdownDescription: ConcatDefault("Enable Hubble UI", "false"),
 				Optional:            true,
@@ -70,6 +81,14 @@ func (r *CiliumHubbleResource) Schema(ctx context.Context, req resource.SchemaRe
 				},
 			},
 		},
+		Blocks: map[string]schema.Block{
+			"timeouts": timeouts.Block(ctx, timeouts.Opts{
+				Create: true,
+				Read:   true,
+				Update: true,
+				Delete: true,
+			}),
+		},
 	}
 }
 
@@ -95,27 +114,44 @@ func (r *CiliumHubbleResource) Configure(ctx context.Context, req resource.Confi
 
 func (r *CiliumHubbleResource) Create(ctx context.Context, req resource.CreateRequest, resp *resource.CreateResponse) {
 	var data CiliumHubbleResourceModel
-	c := r.client
+
+	// Read Terraform plan data into the model
+	resp.Diagnostics.Append(req.Plan.Get(ctx, &data)...)
+
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	c := r.client.ForRelease(data.Namespace, data.HelmRelease)
 	if c == nil {
 		resp.Diagnostics.AddError("Client Error", "Unable to connect to kubernetes")
 		return
 	}
-	k8sClient, namespace, helm_release := c.client, c.namespace, c.helm_release
-	var params = hubble.Parameters{Writer: os.Stdout}
-
-	// Read Terraform plan data into the model
-	resp.Diagnostics.Append(req.Plan.Get(ctx, &data)...)
+	k8sClient, err := c.K8sClient()
+	if err != nil {
+		resp.Diagnostics.AddError("Client Error", fmt.Sprintf("Unable to connect to kubernetes: %s", err))
+		return
+	}
+	namespace, helm_release := c.namespace, c.helm_release
+	var params = hubble.Parameters{Writer: c.LogWriter(ctx, "cilium_hubble")}
 
+	createTimeout, diags := data.Timeouts.Create(ctx, defaults.StatusWaitDuration)
+	resp.Diagnostics.Append(diags...)
 	if resp.Diagnostics.HasError() {
 		return
 	}
+	ctx, cancel := context.WithTimeout(ctx, createTimeout)
+	defer cancel()
+
 	params.Namespace = namespace
 	params.UI = data.UI.ValueBool()
 	params.Relay = data.Relay.ValueBool()
 	params.HelmReleaseName = helm_release
 
-	if err := hubble.EnableWithHelm(context.Background(), k8sClient, params); err != nil {
-		resp.Diagnostics.AddError("Client Error", fmt.Sprintf("Unable to enable Hubble: %s", err))
+	if err := c.HelmMutation(ctx, func() error {
+		return hubbleEnableWithHelm(ctx, k8sClient, params)
+	}); err != nil {
+		resp.Diagnostics.AddError("Client Error", fmt.Sprintf("Unable to enable Hubble: %s", interrupted(ctx, err)))
 		return
 	}
 	// For the purposes of this example code, hardcoding a response value to
@@ -140,34 +176,82 @@ func (r *CiliumHubbleResource) Read(ctx context.Context, req resource.ReadReques
 		return
 	}
 
+	c := r.client.ForRelease(data.Namespace, data.HelmRelease)
+	if c == nil {
+		resp.Diagnostics.AddError("Client Error", "Unable to connect to kubernetes")
+		return
+	}
+	_, err := c.K8sClient()
+	if errors.Is(err, errProviderConfigUnknown) {
+		// Keep the prior state until the provider configuration is known.
+		return
+	}
+	if err != nil {
+		resp.Diagnostics.AddError("Client Error", fmt.Sprintf("Unable to connect to kubernetes: %s", err))
+		return
+	}
+
+	readTimeout, diags := data.Timeouts.Read(ctx, defaults.StatusWaitDuration)
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+	ctx, cancel := context.WithTimeout(ctx, readTimeout)
+	defer cancel()
+
+	// Hubble goes away with the Cilium release.
+	if _, err := c.GetReleaseSnapshot(); isNotFound(err) {
+		resp.State.RemoveResource(ctx)
+		return
+	} else if err != nil {
+		resp.Diagnostics.AddError("Client Error", fmt.Sprintf("Unable to read Cilium release: %s", interrupted(ctx, err)))
+		return
+	}
+
 	// Save updated data into Terraform state
 	resp.Diagnostics.Append(resp.State.Set(ctx, &data)...)
 }
 
 func (r *CiliumHubbleResource) Update(ctx context.Context, req resource.UpdateRequest, resp *resource.UpdateResponse) {
 	var data CiliumHubbleResourceModel
-	c := r.client
+
+	// Read Terraform plan data into the model
+	resp.Diagnostics.Append(req.Plan.Get(ctx, &data)...)
+
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	c := r.client.ForRelease(data.Namespace, data.HelmRelease)
 	if c == nil {
 		resp.Diagnostics.AddError("Client Error", "Unable to connect to kubernetes")
 		return
 	}
-	k8sClient, namespace, helm_release := c.client, c.namespace, c.helm_release
-	var params = hubble.Parameters{Writer: os.Stdout}
-
-	// Read Terraform plan data into the model
-	resp.Diagnostics.Append(req.Plan.Get(ctx, &data)...)
+	k8sClient, err := c.K8sClient()
+	if err != nil {
+		resp.Diagnostics.AddError("Client Error", fmt.Sprintf("Unable to connect to kubernetes: %s", err))
+		return
+	}
+	namespace, helm_release := c.namespace, c.helm_release
+	var params = hubble.Parameters{Writer: c.LogWriter(ctx, "cilium_hubble")}
 
+	updateTimeout, diags := data.Timeouts.Update(ctx, defaults.StatusWaitDuration)
+	resp.Diagnostics.Append(diags...)
 	if resp.Diagnostics.HasError() {
 		return
 	}
+	ctx, cancel := context.WithTimeout(ctx, updateTimeout)
+	defer cancel()
 
 	params.Namespace = namespace
 	params.UI = data.UI.ValueBool()
 	params.Relay = data.Relay.ValueBool()
 	params.HelmReleaseName = helm_release
 
-	if err := hubble.EnableWithHelm(context.Background(), k8sClient, params); err != nil {
-		resp.Diagnostics.AddError("Client Error", fmt.Sprintf("Unable to update Hubble: %s", err))
+	if err := c.HelmMutation(ctx, func() error {
+		return hubbleEnableWithHelm(ctx, k8sClient, params)
+	}); err != nil {
+		resp.Diagnostics.AddError("Client Error", fmt.Sprintf("Unable to update Hubble: %s", interrupted(ctx, err)))
 		return
 	}
 
@@ -177,26 +261,42 @@ func (r *CiliumHubbleResource) Update(ctx context.Context, req resource.UpdateRe
 
 func (r *CiliumHubbleResource) Delete(ctx context.Context, req resource.DeleteRequest, resp *resource.DeleteResponse) {
 	var data CiliumHubbleResourceModel
-	c := r.client
+
+	// Read Terraform prior state data into the model
+	resp.Diagnostics.Append(req.State.Get(ctx, &data)...)
+
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	c := r.client.ForRelease(data.Namespace, data.HelmRelease)
 	if c == nil {
 		resp.Diagnostics.AddError("Client Error", "Unable to connect to kubernetes")
 		return
 	}
-	k8sClient, namespace, helm_release := c.client, c.namespace, c.helm_release
-	var params = hubble.Parameters{Writer: os.Stdout}
-
-	// Read Terraform prior state data into the model
-	resp.Diagnostics.Append(req.State.Get(ctx, &data)...)
+	k8sClient, err := c.K8sClient()
+	if err != nil {
+		resp.Diagnostics.AddError("Client Error", fmt.Sprintf("Unable to connect to kubernetes: %s", err))
+		return
+	}
+	namespace, helm_release := c.namespace, c.helm_release
+	var params = hubble.Parameters{Writer: c.LogWriter(ctx, "cilium_hubble")}
 
+	deleteTimeout, diags := data.Timeouts.Delete(ctx, defaults.StatusWaitDuration)
+	resp.Diagnostics.Append(diags...)
 	if resp.Diagnostics.HasError() {
 		return
 	}
+	ctx, cancel := context.WithTimeout(ctx, deleteTimeout)
+	defer cancel()
 
 	params.Namespace = namespace
 	params.HelmReleaseName = helm_release
 
-	if err := hubble.DisableWithHelm(context.Background(), k8sClient, params); err != nil {
-		resp.Diagnostics.AddError("Client Error", fmt.Sprintf("Unable to disable Hubble: %s", err))
+	if err := c.HelmMutation(ctx, func() error {
+		return hubble.DisableWithHelm(ctx, k8sClient, params)
+	}); err != nil {
+		resp.Diagnostics.AddError("Client Error", fmt.Sprintf("Unable to disable Hubble: %s", interrupted(ctx, err)))
 		return
 	}
 }
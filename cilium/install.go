@@ -5,24 +5,35 @@ package provider
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"os"
-	"time"
+	"regexp"
+	"strings"
 
 	"github.com/cilium/cilium/cilium-cli/defaults"
 	"github.com/cilium/cilium/cilium-cli/install"
 
+	"helm.sh/helm/v3/pkg/cli"
 	"helm.sh/helm/v3/pkg/cli/values"
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"helm.sh/helm/v3/pkg/getter"
+	"helm.sh/helm/v3/pkg/registry"
+	"helm.sh/helm/v3/pkg/release"
 
+	"github.com/hashicorp/terraform-plugin-framework-timeouts/resource/timeouts"
+	"github.com/hashicorp/terraform-plugin-framework-validators/int64validator"
+	"github.com/hashicorp/terraform-plugin-framework-validators/stringvalidator"
+	"github.com/hashicorp/terraform-plugin-framework/attr"
 	"github.com/hashicorp/terraform-plugin-framework/path"
 	"github.com/hashicorp/terraform-plugin-framework/resource"
 	"github.com/hashicorp/terraform-plugin-framework/resource/schema"
 	"github.com/hashicorp/terraform-plugin-framework/resource/schema/booldefault"
+	"github.com/hashicorp/terraform-plugin-framework/resource/schema/int64planmodifier"
 	"github.com/hashicorp/terraform-plugin-framework/resource/schema/listdefault"
 	"github.com/hashicorp/terraform-plugin-framework/resource/schema/planmodifier"
 	"github.com/hashicorp/terraform-plugin-framework/resource/schema/stringdefault"
 	"github.com/hashicorp/terraform-plugin-framework/resource/schema/stringplanmodifier"
+	"github.com/hashicorp/terraform-plugin-framework/schema/validator"
 	"github.com/hashicorp/terraform-plugin-framework/types"
 	"github.com/hashicorp/terraform-plugin-log/tflog"
 )
@@ -30,6 +41,10 @@ import (
 // Ensure provider defined types fully satisfy framework interfaces.
 var _ resource.Resource = &CiliumInstallResource{}
 var _ resource.ResourceWithImportState = &CiliumInstallResource{}
+var _ resource.ResourceWithModifyPlan = &CiliumInstallResource{}
+
+// clusterNameRegexp matches the cluster names accepted by Cilium.
+var clusterNameRegexp = regexp.MustCompile(`^[a-z0-9]([-a-z0-9]*[a-z0-9])?$`)
 
 func NewCiliumInstallResource() resource.Resource {
 	return &CiliumInstallResource{}
@@ -42,18 +57,45 @@ type CiliumInstallResource struct {
 
 // CiliumInstallResourceModel describes the resource data model.
 type CiliumInstallResourceModel struct {
-	HelmSet        types.List   `tfsdk:"set"`
-	Values         types.String `tfsdk:"values"`
-	Version        types.String `tfsdk:"version"`
-	Repository     types.String `tfsdk:"repository"`
-	DataPath       types.String `tfsdk:"data_path"`
-	Wait           types.Bool   `tfsdk:"wait"`
-	Reuse          types.Bool   `tfsdk:"reuse"`
-	Reset          types.Bool   `tfsdk:"reset"`
-	ResetThenReuse types.Bool   `tfsdk:"reusethenreuse"`
-	Id             types.String `tfsdk:"id"`
-	HelmValues     types.String `tfsdk:"helm_values"`
-	CA             types.Object `tfsdk:"ca"`
+	HelmSet          types.List     `tfsdk:"set"`
+	SetString        types.List     `tfsdk:"set_string"`
+	SetSensitive     types.List     `tfsdk:"set_sensitive"`
+	Values           ValuesValue    `tfsdk:"values"`
+	ValuesObject     types.Dynamic  `tfsdk:"values_object"`
+	Version          types.String   `tfsdk:"version"`
+	Repository       types.String   `tfsdk:"repository"`
+	ChartPath        types.String   `tfsdk:"chart_path"`
+	Verify           types.Bool     `tfsdk:"verify"`
+	Keyring          types.String   `tfsdk:"keyring"`
+	DataPath         types.String   `tfsdk:"data_path"`
+	ClusterName      types.String   `tfsdk:"cluster_name"`
+	ClusterId        types.Int64    `tfsdk:"cluster_id"`
+	Wait             types.Bool     `tfsdk:"wait"`
+	Reuse            types.Bool     `tfsdk:"reuse"`
+	Reset            types.Bool     `tfsdk:"reset"`
+	ResetThenReuse   types.Bool     `tfsdk:"reusethenreuse"`
+	RecoverPending   types.Bool     `tfsdk:"recover_pending"`
+	Atomic           types.Bool     `tfsdk:"atomic"`
+	Namespace        types.String   `tfsdk:"namespace"`
+	HelmRelease      types.String   `tfsdk:"helm_release"`
+	Id               types.String   `tfsdk:"id"`
+	HelmValues       ValuesValue    `tfsdk:"helm_values"`
+	HelmValuesObject types.Dynamic  `tfsdk:"helm_values_object"`
+	CA               types.Object   `tfsdk:"ca"`
+	Revision         types.Int64    `tfsdk:"revision"`
+	Status           types.String   `tfsdk:"status"`
+	LastDeployed     types.String   `tfsdk:"last_deployed"`
+	ManifestDiff     types.String   `tfsdk:"manifest_diff"`
+	DetectedDataPath types.String   `tfsdk:"detected_data_path"`
+	DetectedPlatform types.String   `tfsdk:"detected_platform"`
+	CiliumStatus     types.Object   `tfsdk:"cilium_status"`
+	Timeouts         timeouts.Value `tfsdk:"timeouts"`
+}
+
+// CiliumInstallSetModel describes a helm value set by name.
+type CiliumInstallSetModel struct {
+	Name  types.String `tfsdk:"name"`
+	Value types.String `tfsdk:"value"`
 }
 
 func (r *CiliumInstallResource) Metadata(ctx context.Context, req resource.MetadataRequest, resp *resource.MetadataResponse) {
@@ -61,11 +103,14 @@ func (r *CiliumInstallResource) Metadata(ctx context.Context, req resource.Metad
 }
 
 func (r *CiliumInstallResource) Schema(ctx context.Context, req resource.SchemaRequest, resp *resource.SchemaResponse) {
+	namespace, helmRelease := releaseAttributes(func() *CiliumClient { return r.client })
 	resp.Schema = schema.Schema{
 		// This description is used by the documentation generator and the language server.
 		MarkdownDescription: "Install resource for Cilium. This is equivalent to cilium cli: `cilium install`, `cilium upgrade` and `cilium uninstall`: It manages cilium helm chart",
 
 		Attributes: map[string]schema.Attribute{
+			"namespace":    namespace,
+			"helm_release": helmRelease,
 			"ca": schema.ObjectAttribute{
 				AttributeTypes: CaAttributeTypes,
 				Computed:       true,
@@ -78,12 +123,50 @@ func (r *CiliumInstallResource) Schema(ctx context.Context, req resource.SchemaR
 				Computed:            true,
 				Default:             listdefault.StaticValue(types.ListNull(types.StringType)),
 			},
+			"set_string": schema.ListNestedAttribute{
+				MarkdownDescription: "Set helm values as strings, like `--set-string`",
+				Optional:            true,
+				NestedObject: schema.NestedAttributeObject{
+					Attributes: map[string]schema.Attribute{
+						"name": schema.StringAttribute{
+							MarkdownDescription: "Path of the value (e.g. `cluster.name`)",
+							Required:            true,
+						},
+						"value": schema.StringAttribute{
+							MarkdownDescription: "Value, always a string",
+							Required:            true,
+						},
+					},
+				},
+			},
+			"set_sensitive": schema.ListNestedAttribute{
+				MarkdownDescription: "Set sensitive helm values, like `--set-literal`: the value is taken as is, commas included, and is hidden from the plan",
+				Optional:            true,
+				NestedObject: schema.NestedAttributeObject{
+					Attributes: map[string]schema.Attribute{
+						"name": schema.StringAttribute{
+							MarkdownDescription: "Path of the value (e.g. `hubble.tls.server.key`)",
+							Required:            true,
+						},
+						"value": schema.StringAttribute{
+							MarkdownDescription: "Value, always a string",
+							Required:            true,
+							Sensitive:           true,
+						},
+					},
+				},
+			},
 			"values": schema.StringAttribute{
-				MarkdownDescription: ConcatDefault("values in raw yaml to pass to helm.", "empty"),
+				CustomType:          ValuesType{},
+				MarkdownDescription: ConcatDefault("values in raw yaml to pass to helm. Reformatting them (e.g. reordering the keys) is not a change", "empty"),
 				Optional:            true,
 				Computed:            true,
 				Default:             stringdefault.StaticString(""),
 			},
+			"values_object": schema.DynamicAttribute{
+				MarkdownDescription: "values as an object to pass to helm, merged over `values`",
+				Optional:            true,
+			},
 			"version": schema.StringAttribute{
 				MarkdownDescription: ConcatDefault("Version of Cilium", "1.17.3"),
 				Optional:            true,
@@ -91,16 +174,68 @@ func (r *CiliumInstallResource) Schema(ctx context.Context, req resource.SchemaR
 				Default:             stringdefault.StaticString("1.17.3"),
 			},
 			"repository": schema.StringAttribute{
-				MarkdownDescription: ConcatDefault("Helm chart repository to download Cilium charts from", defaults.HelmRepository),
+				MarkdownDescription: ConcatDefault("Helm chart repository to download Cilium charts from, or reference of the chart in an OCI registry (e.g. `oci://quay.io/cilium/charts/cilium`)", defaults.HelmRepository),
 				Optional:            true,
 				Computed:            true,
 				Default:             stringdefault.StaticString(defaults.HelmRepository),
 			},
+			"chart_path": schema.StringAttribute{
+				MarkdownDescription: "Path of a local Cilium chart, a directory or a `.tgz` archive, installed instead of the chart of `repository`. `version` must be the version of the chart",
+				Optional:            true,
+			},
+			"verify": schema.BoolAttribute{
+				MarkdownDescription: ConcatDefault("Verify the chart against its provenance file (`.prov`) before installing or upgrading it, like `helm install --verify`. The chart is downloaded from `repository` instead of using the charts embedded in cilium-cli; a local chart must be a `.tgz` archive with the provenance file next to it", "false"),
+				Optional:            true,
+				Computed:            true,
+				Default:             booldefault.StaticBool(false),
+			},
+			"keyring": schema.StringAttribute{
+				MarkdownDescription: ConcatDefault("Keyring of the public keys used to verify the chart", "~/.gnupg/pubring.gpg"),
+				Optional:            true,
+			},
 			"data_path": schema.StringAttribute{
-				MarkdownDescription: ConcatDefault("Datapath mode to use { tunnel | native | aws-eni | gke | azure | aks-byocni }", "autodetected"),
+				MarkdownDescription: ConcatDefault("Datapath mode to use { tunnel | native | aws-eni | gke | azure | aks-byocni }. Changing it replaces the resource, unless it is removed or set to `detected_data_path`", "autodetected"),
 				Optional:            true,
 				Computed:            true,
-				Default:             stringdefault.StaticString(""),
+				Validators: []validator.String{
+					stringvalidator.OneOf("", install.DatapathTunnel, install.DatapathNative, install.DatapathAwsENI, install.DatapathGKE, install.DatapathAzure, install.DatapathAKSBYOCNI),
+				},
+				PlanModifiers: []planmodifier.String{
+					// Without data_path, the datapath mode of the release (e.g.
+					// the imported one) is kept.
+					stringplanmodifier.UseStateForUnknown(),
+					stringplanmodifier.RequiresReplaceIf(func(ctx context.Context, req planmodifier.StringRequest, resp *stringplanmodifier.RequiresReplaceIfFuncResponse) {
+						// cilium-cli applies the datapath mode at install only.
+						var detected types.String
+						resp.Diagnostics.Append(req.State.GetAttribute(ctx, path.Root("detected_data_path"), &detected)...)
+						resp.RequiresReplace = req.PlanValue.ValueString() != "" && req.PlanValue.ValueString() != detected.ValueString()
+					}, "Changing the datapath mode replaces the resource.", "Changing the datapath mode replaces the resource."),
+				},
+			},
+			"cluster_name": schema.StringAttribute{
+				MarkdownDescription: ConcatDefault("Name of the cluster (`cluster.name`), unique in a Cluster Mesh. Changing it replaces the resource", "autodetected"),
+				Optional:            true,
+				Computed:            true,
+				PlanModifiers: []planmodifier.String{
+					stringplanmodifier.UseStateForUnknown(),
+					stringplanmodifier.RequiresReplaceIfConfigured(),
+				},
+				Validators: []validator.String{
+					stringvalidator.LengthBetween(1, 32),
+					stringvalidator.RegexMatches(clusterNameRegexp, "must consist of lower case alphanumeric characters and '-', and must start and end with an alphanumeric character"),
+				},
+			},
+			"cluster_id": schema.Int64Attribute{
+				MarkdownDescription: "ID of the cluster (`cluster.id`), unique in a Cluster Mesh: from 1 to 255, or to 511 with `clustermesh.maxConnectedClusters` set to 511. Changing it replaces the resource",
+				Optional:            true,
+				Computed:            true,
+				PlanModifiers: []planmodifier.Int64{
+					int64planmodifier.UseStateForUnknown(),
+					int64planmodifier.RequiresReplaceIfConfigured(),
+				},
+				Validators: []validator.Int64{
+					int64validator.Between(1, 511),
+				},
 			},
 			"reuse": schema.BoolAttribute{
 				MarkdownDescription: ConcatDefault("When upgrading, reuse the helm values from the latest release unless any overrides from are set from other flags. This option takes precedence over HelmResetValues", "false"),
@@ -120,6 +255,18 @@ func (r *CiliumInstallResource) Schema(ctx context.Context, req resource.SchemaR
 				Computed:            true,
 				Default:             booldefault.StaticBool(true),
 			},
+			"recover_pending": schema.BoolAttribute{
+				MarkdownDescription: ConcatDefault("When upgrading, recover a release left in a pending state (e.g. `pending-upgrade` after an interrupted apply): roll it back to the last deployed revision, or mark it as failed when there is none, before upgrading it. Only enable it when no other tool upgrades the release", "false"),
+				Optional:            true,
+				Computed:            true,
+				Default:             booldefault.StaticBool(false),
+			},
+			"atomic": schema.BoolAttribute{
+				MarkdownDescription: ConcatDefault("When upgrading, roll back to the previous revision of the release if the upgrade or the wait for Cilium status fails", "false"),
+				Optional:            true,
+				Computed:            true,
+				Default:             booldefault.StaticBool(false),
+			},
 			"wait": schema.BoolAttribute{
 				MarkdownDescription: ConcatDefault("Wait for Cilium status is ok", "true"),
 				Optional:            true,
@@ -134,9 +281,54 @@ func (r *CiliumInstallResource) Schema(ctx context.Context, req resource.SchemaR
 				},
 			},
 			"helm_values": schema.StringAttribute{
+				CustomType:          ValuesType{},
+				Computed:            true,
+				MarkdownDescription: "Helm values (`helm get values -n kube-system cilium`). The values of an upgrade are computed during the plan. The values of `set_sensitive` are replaced by `(sensitive value)`",
+			},
+			"helm_values_object": schema.DynamicAttribute{
+				Computed:            true,
+				MarkdownDescription: "Helm values as an object, e.g. `cilium.this.helm_values_object.ipam.mode`",
+			},
+			"revision": schema.Int64Attribute{
+				Computed:            true,
+				MarkdownDescription: "Revision of the Helm release",
+			},
+			"status": schema.StringAttribute{
+				Computed:            true,
+				MarkdownDescription: "Status of the Helm release (e.g. `deployed`)",
+			},
+			"last_deployed": schema.StringAttribute{
+				Computed:            true,
+				MarkdownDescription: "Date of the last deployment of the Helm release (RFC 3339)",
+			},
+			"manifest_diff": schema.StringAttribute{
 				Computed:            true,
-				MarkdownDescription: "Helm values (`helm get values -n kube-system cilium`)",
+				MarkdownDescription: "Objects added (`+`), changed (`~`) and removed (`-`) by the last upgrade, one per line. It is rendered during the plan so that it previews the upgrade",
 			},
+			"detected_data_path": schema.StringAttribute{
+				Computed:            true,
+				MarkdownDescription: "Datapath mode of the release, derived from its values and the defaults of the chart (e.g. `tunnel`, `native` or `aws-eni`): the one chosen by the autodetection when `data_path` is not set",
+			},
+			"cilium_status": schema.ObjectAttribute{
+				AttributeTypes:      StatusAttributeTypes,
+				Computed:            true,
+				MarkdownDescription: "Cilium status collected by the last wait (`cilium status`), null without `wait`. It is not named `status`, which is the status of the Helm release. Pod counts of the components (`desired`, `ready`, `available`, `unavailable`) by name, images in use by component, `pods` and `unmanaged_pods` of the cluster and `errors`",
+			},
+			"detected_platform": schema.StringAttribute{
+				Computed:            true,
+				MarkdownDescription: "Kubernetes platform detected by cilium-cli { kind | minikube | eks | gke | aks | k3s | microk8s | rancher-desktop | openshift | unknown } at create or import",
+				PlanModifiers: []planmodifier.String{
+					stringplanmodifier.UseStateForUnknown(),
+				},
+			},
+		},
+		Blocks: map[string]schema.Block{
+			"timeouts": timeouts.Block(ctx, timeouts.Opts{
+				Create: true,
+				Read:   true,
+				Update: true,
+				Delete: true,
+			}),
 		},
 	}
 }
@@ -163,78 +355,116 @@ func (r *CiliumInstallResource) Configure(ctx context.Context, req resource.Conf
 
 func (r *CiliumInstallResource) Create(ctx context.Context, req resource.CreateRequest, resp *resource.CreateResponse) {
 	var data CiliumInstallResourceModel
-	c := r.client
+
+	// Read Terraform plan data into the model
+	resp.Diagnostics.Append(req.Plan.Get(ctx, &data)...)
+
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	c := r.client.ForRelease(data.Namespace, data.HelmRelease)
 	if c == nil {
 		resp.Diagnostics.AddError("Client Error", "Unable to connect to kubernetes")
 		return
 	}
-	k8sClient, namespace, helm_release := c.client, c.namespace, c.helm_release
-	var params = install.Parameters{Writer: os.Stdout}
-	var options values.Options
-
-	// Read Terraform plan data into the model
-	resp.Diagnostics.Append(req.Plan.Get(ctx, &data)...)
+	k8sClient, err := c.K8sClient()
+	if err != nil {
+		resp.Diagnostics.AddError("Client Error", fmt.Sprintf("Unable to connect to kubernetes: %s", err))
+		return
+	}
+	namespace, helm_release := c.namespace, c.helm_release
+	var params = install.Parameters{Writer: c.LogWriter(ctx, "cilium")}
 
+	createTimeout, diags := data.Timeouts.Create(ctx, defaults.StatusWaitDuration)
+	resp.Diagnostics.Append(diags...)
 	if resp.Diagnostics.HasError() {
 		return
 	}
+	ctx, cancel := context.WithTimeout(ctx, createTimeout)
+	defer cancel()
+
 	params.Namespace = namespace
 	params.Version = data.Version.ValueString()
 	params.HelmReleaseName = helm_release
+	params.DatapathMode = data.DataPath.ValueString()
 	wait := data.Wait.ValueBool()
 
-	options.Values = ValueList(ctx, data.HelmSet)
-
-	values := data.Values.ValueString()
-
-	if values != "" {
-		f, err := os.CreateTemp("", ".values.*.yaml")
-		if err != nil {
-			resp.Diagnostics.AddError("Client Error", fmt.Sprintf("Unable to create Cilium installer: %s", err))
-		}
-		defer os.Remove(f.Name())
-
-		if _, err := f.Write([]byte(values)); err != nil {
-			f.Close()
-			resp.Diagnostics.AddError("Client Error", fmt.Sprintf("Unable to create Cilium installer: %s", err))
-		}
-		if err := f.Close(); err != nil {
-			resp.Diagnostics.AddError("Client Error", fmt.Sprintf("Unable to create Cilium installer: %s", err))
-		}
-		options.ValueFiles = []string{f.Name()}
+	options, cleanup, err := data.helmOptions(ctx)
+	if err != nil {
+		resp.Diagnostics.AddError("Client Error", fmt.Sprintf("Unable to create Cilium installer: %s", err))
+		return
 	}
+	defer cleanup()
 
 	params.HelmOpts = options
 
+	chartCleanup, err := data.chartParameters(&params)
+	if err != nil {
+		resp.Diagnostics.AddError("Client Error", fmt.Sprintf("Unable to load Cilium chart: %s", interrupted(ctx, err)))
+		return
+	}
+	defer chartCleanup()
+
 	installer, err := install.NewK8sInstaller(k8sClient, params)
 	if err != nil {
-		resp.Diagnostics.AddError("Client Error", fmt.Sprintf("Unable to create Cilium installer: %s", err))
+		resp.Diagnostics.AddError("Client Error", fmt.Sprintf("Unable to create Cilium installer: %s", interrupted(ctx, err)))
 		return
 	}
 
-	if err := installer.InstallWithHelm(context.Background(), k8sClient); err != nil {
-		resp.Diagnostics.AddError("Client Error", fmt.Sprintf("Unable to install Cilium: %s", err))
+	if err := c.HelmMutation(ctx, func() error {
+		return installer.InstallWithHelm(ctx, k8sClient)
+	}); err != nil {
+		resp.Diagnostics.AddError("Client Error", fmt.Sprintf("Unable to install Cilium: %s", interrupted(ctx, err)))
 		return
 	}
 
+	data.CiliumStatus = types.ObjectNull(StatusAttributeTypes)
 	if wait {
-		if err := c.Wait(); err != nil {
-			return
+		collected, err := c.Wait(ctx, createTimeout)
+		data.CiliumStatus = StatusValue(collected)
+		if err != nil {
+			resp.Diagnostics.AddError("Client Error", fmt.Sprintf("Cilium status is not ok: %s%s", interrupted(ctx, err), StatusSummary(collected)))
+			// Cilium is installed: the resource is saved, tainted, so that
+			// the next apply replaces it.
+			ctx = context.WithoutCancel(ctx)
 		}
 	}
 	data.Id = types.StringValue(helm_release)
-	helm_values, err := c.GetHelmValues()
+	snapshot, err := c.GetReleaseSnapshot()
+	if err != nil {
+		resp.Diagnostics.AddError("Client Error", fmt.Sprintf("Unable to install Cilium: %s", interrupted(ctx, err)))
+		return
+	}
+	snapshot.Redact(data.sensitivePaths(ctx))
+	helm_values, err := snapshot.Values()
+	if err != nil {
+		resp.Diagnostics.AddError("Client Error", fmt.Sprintf("Unable to install Cilium: %s", interrupted(ctx, err)))
+		return
+	}
+	ca, err := snapshot.CA(ctx)
 	if err != nil {
-		resp.Diagnostics.AddError("Client Error", fmt.Sprintf("Unable to install Cilium: %s", err))
+		resp.Diagnostics.AddError("Client Error", fmt.Sprintf("Unable to retrieve cilium-ca: %s", interrupted(ctx, err)))
 		return
 	}
-	ca, err := c.GetCA(ctx)
+	data.CA = types.ObjectValueMust(CaAttributeTypes, c.StateCA(ca))
+	data.HelmValues = NewValuesValue(helm_values)
+	helm_values_object, err := snapshot.ValuesObject()
 	if err != nil {
-		resp.Diagnostics.AddError("Client Error", fmt.Sprintf("Unable to retrieve cilium-ca: %s", err))
+		resp.Diagnostics.AddError("Client Error", fmt.Sprintf("Unable to read Cilium values: %s", err))
 		return
 	}
-	data.CA = types.ObjectValueMust(CaAttributeTypes, ca)
-	data.HelmValues = types.StringValue(helm_values)
+	data.HelmValuesObject = helm_values_object
+	data.Revision, data.Status, data.LastDeployed = snapshot.Revision()
+	data.DetectedDataPath = types.StringValue(snapshot.DatapathMode())
+	if data.DataPath.IsUnknown() {
+		data.DataPath = data.DetectedDataPath
+	}
+	data.ClusterName, data.ClusterId = snapshot.ClusterIdentity()
+	data.DetectedPlatform = types.StringValue(c.DetectPlatform(ctx))
+	if data.ManifestDiff.IsUnknown() {
+		data.ManifestDiff = types.StringNull()
+	}
 
 	// Write logs using the tflog package
 	// Documentation: https://terraform.io/plugin/log
@@ -246,11 +476,6 @@ func (r *CiliumInstallResource) Create(ctx context.Context, req resource.CreateR
 
 func (r *CiliumInstallResource) Read(ctx context.Context, req resource.ReadRequest, resp *resource.ReadResponse) {
 	var data CiliumInstallResourceModel
-	c := r.client
-	if c == nil {
-		resp.Diagnostics.AddError("Client Error", "Unable to connect to kubernetes")
-		return
-	}
 
 	// Read Terraform prior state data into the model
 	resp.Diagnostics.Append(req.State.Get(ctx, &data)...)
@@ -259,28 +484,64 @@ func (r *CiliumInstallResource) Read(ctx context.Context, req resource.ReadReque
 		return
 	}
 
-	_, err := c.GetCurrentRelease()
+	c := r.client.ForRelease(data.Namespace, data.HelmRelease)
+	if c == nil {
+		resp.Diagnostics.AddError("Client Error", "Unable to connect to kubernetes")
+		return
+	}
+	_, err := c.K8sClient()
+	if errors.Is(err, errProviderConfigUnknown) {
+		// Keep the prior state until the provider configuration is known.
+		return
+	}
 	if err != nil {
+		resp.Diagnostics.AddError("Client Error", fmt.Sprintf("Unable to connect to kubernetes: %s", err))
+		return
+	}
+
+	readTimeout, diags := data.Timeouts.Read(ctx, defaults.StatusWaitDuration)
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+	ctx, cancel := context.WithTimeout(ctx, readTimeout)
+	defer cancel()
+
+	snapshot, err := c.GetReleaseSnapshot()
+	if isNotFound(err) {
 		resp.State.RemoveResource(ctx)
 		return
 	}
-	helm_values, err := c.GetHelmValues()
 	if err != nil {
-		resp.Diagnostics.AddError("Client Error", fmt.Sprintf("Unable to read tfstate: %s", err))
+		resp.Diagnostics.AddError("Client Error", fmt.Sprintf("Unable to read Cilium release: %s", interrupted(ctx, err)))
+		return
+	}
+	snapshot.Redact(data.sensitivePaths(ctx))
+	helm_values, err := snapshot.Values()
+	if err != nil {
+		resp.Diagnostics.AddError("Client Error", fmt.Sprintf("Unable to read tfstate: %s", interrupted(ctx, err)))
 		return
 	}
-	version, err := c.GetMetadata()
+	version := snapshot.Version()
+	ca, err := snapshot.CA(ctx)
 	if err != nil {
-		resp.Diagnostics.AddError("Client Error", fmt.Sprintf("Unable to read tfstate: %s", err))
+		resp.Diagnostics.AddError("Client Error", fmt.Sprintf("Unable to retrieve cilium-ca: %s", interrupted(ctx, err)))
 		return
 	}
-	ca, err := c.GetCA(ctx)
+	data.CA = types.ObjectValueMust(CaAttributeTypes, c.StateCA(ca))
+	data.HelmValues = NewValuesValue(helm_values)
+	helm_values_object, err := snapshot.ValuesObject()
 	if err != nil {
-		resp.Diagnostics.AddError("Client Error", fmt.Sprintf("Unable to retrieve cilium-ca: %s", err))
+		resp.Diagnostics.AddError("Client Error", fmt.Sprintf("Unable to read Cilium values: %s", err))
 		return
 	}
-	data.CA = types.ObjectValueMust(CaAttributeTypes, ca)
-	data.HelmValues = types.StringValue(helm_values)
+	data.HelmValuesObject = helm_values_object
+	data.Revision, data.Status, data.LastDeployed = snapshot.Revision()
+	data.DetectedDataPath = types.StringValue(snapshot.DatapathMode())
+	if data.DataPath.IsUnknown() {
+		data.DataPath = data.DetectedDataPath
+	}
+	data.ClusterName, data.ClusterId = snapshot.ClusterIdentity()
 	data.Version = types.StringValue(version)
 
 	// Save updated data into Terraform state
@@ -289,21 +550,34 @@ func (r *CiliumInstallResource) Read(ctx context.Context, req resource.ReadReque
 
 func (r *CiliumInstallResource) Update(ctx context.Context, req resource.UpdateRequest, resp *resource.UpdateResponse) {
 	var data CiliumInstallResourceModel
-	c := r.client
+
+	// Read Terraform plan data into the model
+	resp.Diagnostics.Append(req.Plan.Get(ctx, &data)...)
+
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	c := r.client.ForRelease(data.Namespace, data.HelmRelease)
 	if c == nil {
 		resp.Diagnostics.AddError("Client Error", "Unable to connect to kubernetes")
 		return
 	}
-	k8sClient, namespace, helm_release := c.client, c.namespace, c.helm_release
-	var params = install.Parameters{Writer: os.Stdout}
-	var options values.Options
-
-	// Read Terraform plan data into the model
-	resp.Diagnostics.Append(req.Plan.Get(ctx, &data)...)
+	k8sClient, err := c.K8sClient()
+	if err != nil {
+		resp.Diagnostics.AddError("Client Error", fmt.Sprintf("Unable to connect to kubernetes: %s", err))
+		return
+	}
+	namespace, helm_release := c.namespace, c.helm_release
+	var params = install.Parameters{Writer: c.LogWriter(ctx, "cilium")}
 
+	updateTimeout, diags := data.Timeouts.Update(ctx, defaults.StatusWaitDuration)
+	resp.Diagnostics.Append(diags...)
 	if resp.Diagnostics.HasError() {
 		return
 	}
+	ctx, cancel := context.WithTimeout(ctx, updateTimeout)
+	defer cancel()
 
 	params.Namespace = namespace
 	params.Version = data.Version.ValueString()
@@ -313,106 +587,495 @@ func (r *CiliumInstallResource) Update(ctx context.Context, req resource.UpdateR
 	params.HelmResetThenReuseValues = data.ResetThenReuse.ValueBool()
 	wait := data.Wait.ValueBool()
 
-	options.Values = ValueList(ctx, data.HelmSet)
-
-	values := data.Values.ValueString()
-
-	if values != "" {
-		f, err := os.CreateTemp("", ".values.*.yaml")
-		if err != nil {
-			resp.Diagnostics.AddError("Client Error", fmt.Sprintf("Unable to create Cilium installer: %s", err))
-		}
-		defer os.Remove(f.Name())
-
-		if _, err := f.Write([]byte(values)); err != nil {
-			f.Close()
-			resp.Diagnostics.AddError("Client Error", fmt.Sprintf("Unable to create Cilium installer: %s", err))
-		}
-		if err := f.Close(); err != nil {
-			resp.Diagnostics.AddError("Client Error", fmt.Sprintf("Unable to create Cilium installer: %s", err))
-		}
-		options.ValueFiles = []string{f.Name()}
+	options, cleanup, err := data.helmOptions(ctx)
+	if err != nil {
+		resp.Diagnostics.AddError("Client Error", fmt.Sprintf("Unable to create Cilium installer: %s", err))
+		return
 	}
+	defer cleanup()
 	params.HelmOpts = options
 
+	chartCleanup, err := data.chartParameters(&params)
+	if err != nil {
+		resp.Diagnostics.AddError("Client Error", fmt.Sprintf("Unable to load Cilium chart: %s", interrupted(ctx, err)))
+		return
+	}
+	defer chartCleanup()
+
 	installer, err := install.NewK8sInstaller(k8sClient, params)
 	if err != nil {
-		resp.Diagnostics.AddError("Client Error", fmt.Sprintf("Unable to upgrade Cilium: %s", err))
+		resp.Diagnostics.AddError("Client Error", fmt.Sprintf("Unable to upgrade Cilium: %s", interrupted(ctx, err)))
 		return
 	}
-	if err := installer.UpgradeWithHelm(context.Background(), k8sClient); err != nil {
-		resp.Diagnostics.AddError("Client Error", fmt.Sprintf("Unable to upgrade Cilium: %s", err))
+	recover_pending := data.RecoverPending.ValueBool()
+	atomic := data.Atomic.ValueBool()
+	var previous *release.Release
+	if err := c.HelmMutation(ctx, func() error {
+		if recover_pending {
+			if err := c.RecoverPendingRelease(ctx); err != nil {
+				return err
+			}
+		}
+		var err error
+		if previous, err = c.GetCurrentRelease(); err != nil {
+			return err
+		}
+		return installer.UpgradeWithHelm(ctx, k8sClient)
+	}); err != nil {
+		resp.Diagnostics.AddError("Client Error", fmt.Sprintf("Unable to upgrade Cilium: %s", interrupted(ctx, err)))
+		if atomic && previous != nil {
+			r.rollback(ctx, c, previous, resp)
+		}
 		return
 	}
+	data.CiliumStatus = types.ObjectNull(StatusAttributeTypes)
 	if wait {
-		if err := c.Wait(); err != nil {
+		collected, err := c.Wait(ctx, updateTimeout)
+		data.CiliumStatus = StatusValue(collected)
+		if err != nil {
+			resp.Diagnostics.AddError("Client Error", fmt.Sprintf("Unable to upgrade Cilium: Cilium status is not ok: %s%s", interrupted(ctx, err), StatusSummary(collected)))
+			if atomic {
+				r.rollback(ctx, c, previous, resp)
+			}
 			return
 		}
 	}
 
-	helm_values, err := c.GetHelmValues()
+	snapshot, err := c.GetReleaseSnapshot()
 	if err != nil {
-		resp.Diagnostics.AddError("Client Error", fmt.Sprintf("Unable to upgrade Cilium: %s", err))
+		resp.Diagnostics.AddError("Client Error", fmt.Sprintf("Unable to upgrade Cilium: %s", interrupted(ctx, err)))
 		return
 	}
-	ca, err := c.GetCA(ctx)
+	snapshot.Redact(data.sensitivePaths(ctx))
+	helm_values, err := snapshot.Values()
 	if err != nil {
-		resp.Diagnostics.AddError("Client Error", fmt.Sprintf("Unable to retrieve cilium-ca: %s", err))
+		resp.Diagnostics.AddError("Client Error", fmt.Sprintf("Unable to upgrade Cilium: %s", interrupted(ctx, err)))
 		return
 	}
-	data.CA = types.ObjectValueMust(CaAttributeTypes, ca)
-	data.HelmValues = types.StringValue(helm_values)
+	ca, err := snapshot.CA(ctx)
+	if err != nil {
+		resp.Diagnostics.AddError("Client Error", fmt.Sprintf("Unable to retrieve cilium-ca: %s", interrupted(ctx, err)))
+		return
+	}
+	data.CA = types.ObjectValueMust(CaAttributeTypes, c.StateCA(ca))
+	data.HelmValues = NewValuesValue(helm_values)
+	helm_values_object, err := snapshot.ValuesObject()
+	if err != nil {
+		resp.Diagnostics.AddError("Client Error", fmt.Sprintf("Unable to read Cilium values: %s", err))
+		return
+	}
+	data.HelmValuesObject = helm_values_object
+	data.Revision, data.Status, data.LastDeployed = snapshot.Revision()
+	data.DetectedDataPath = types.StringValue(snapshot.DatapathMode())
+	data.ClusterName, data.ClusterId = snapshot.ClusterIdentity()
+	if data.DetectedPlatform.IsUnknown() {
+		data.DetectedPlatform = types.StringValue(c.DetectPlatform(ctx))
+	}
+	if data.ManifestDiff.IsUnknown() {
+		data.ManifestDiff = types.StringNull()
+	}
 	// Save updated data into Terraform state
 	resp.Diagnostics.Append(resp.State.Set(ctx, &data)...)
 }
 
 func (r *CiliumInstallResource) Delete(ctx context.Context, req resource.DeleteRequest, resp *resource.DeleteResponse) {
 	var data CiliumInstallResourceModel
-	c := r.client
+
+	// Read Terraform prior state data into the model
+	resp.Diagnostics.Append(req.State.Get(ctx, &data)...)
+
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	c := r.client.ForRelease(data.Namespace, data.HelmRelease)
 	if c == nil {
 		resp.Diagnostics.AddError("Client Error", "Unable to connect to kubernetes")
 		return
 	}
-	k8sClient, namespace, helm_release := c.client, c.namespace, c.helm_release
-	var params = install.UninstallParameters{Writer: os.Stdout}
-
-	// Read Terraform prior state data into the model
-	resp.Diagnostics.Append(req.State.Get(ctx, &data)...)
+	k8sClient, err := c.K8sClient()
+	if err != nil {
+		resp.Diagnostics.AddError("Client Error", fmt.Sprintf("Unable to connect to kubernetes: %s", err))
+		return
+	}
+	namespace, helm_release := c.namespace, c.helm_release
+	var params = install.UninstallParameters{Writer: c.LogWriter(ctx, "cilium")}
 
+	deleteTimeout, diags := data.Timeouts.Delete(ctx, defaults.UninstallTimeout)
+	resp.Diagnostics.Append(diags...)
 	if resp.Diagnostics.HasError() {
 		return
 	}
+	ctx, cancel := context.WithTimeout(ctx, deleteTimeout)
+	defer cancel()
 
 	params.Namespace = namespace
 	params.HelmReleaseName = helm_release
 	params.TestNamespace = defaults.ConnectivityCheckNamespace
 	params.Wait = data.Wait.ValueBool()
 
-	params.Timeout = defaults.UninstallTimeout
-	ctxb := context.Background()
+	params.Timeout = deleteTimeout
 
-	uninstaller := install.NewK8sUninstaller(k8sClient, params)
-	uninstaller.DeleteTestNamespace(ctxb)
+	// cilium-cli waits for the test namespace with an uninterruptible sleep:
+	// delete it without waiting and wait here instead.
+	deleteParams := params
+	deleteParams.Wait = false
+	install.NewK8sUninstaller(k8sClient, deleteParams).DeleteTestNamespace(ctx)
 
+	uninstaller := install.NewK8sUninstaller(k8sClient, params)
 	if params.Wait {
-		fmt.Printf("⌛ Waiting to disable Hubble before uninstalling Cilium\n")
-		for {
-			// Wait for the test namespace to be terminated. Subsequent connectivity checks would fail
-			// if the test namespace is in Terminating state.
-			_, err := k8sClient.GetNamespace(ctx, params.TestNamespace, metav1.GetOptions{})
-			if err == nil {
-				time.Sleep(defaults.WaitRetryInterval)
-			} else {
-				break
+		fmt.Fprintf(params.Writer, "⌛ Waiting to disable Hubble before uninstalling Cilium\n")
+		// Wait for the test namespace to be terminated. Subsequent connectivity checks would fail
+		// if the test namespace is in Terminating state.
+		if err := c.WaitNamespaceDeleted(ctx, params.TestNamespace); err != nil {
+			resp.Diagnostics.AddError("Client Error", fmt.Sprintf("Unable to uninstall Cilium: %s", interrupted(ctx, err)))
+			return
+		}
+	}
+	if err := c.HelmMutation(ctx, func() error {
+		return uninstaller.UninstallWithHelm(ctx, k8sClient.HelmActionConfig)
+	}); err != nil {
+		resp.Diagnostics.AddError("Client Error", fmt.Sprintf("⚠ ️ Unable to uninstall Cilium: %s", interrupted(ctx, err)))
+		return
+	}
+}
+
+// helmOptions builds the helm values options of the installer like the flags
+// of cilium-cli: `values` and `values_object` are passed as values files, set
+// entries with --set, --set-string and --set-literal. The returned function
+// removes the values files.
+func (m *CiliumInstallResourceModel) helmOptions(ctx context.Context) (values.Options, func(), error) {
+	var options values.Options
+	cleanup := func() {
+		for _, f := range options.ValueFiles {
+			os.Remove(f)
+		}
+	}
+
+	documents := []string{m.Values.ValueString()}
+	if !m.ValuesObject.IsNull() && !m.ValuesObject.IsUnderlyingValueNull() {
+		v, err := TerraformToValue(ctx, m.ValuesObject)
+		if err != nil {
+			return options, cleanup, fmt.Errorf("invalid values_object: %w", err)
+		}
+		object, ok := v.(map[string]interface{})
+		if !ok {
+			return options, cleanup, fmt.Errorf("invalid values_object: expected an object or a map, got %T", v)
+		}
+		document, err := ValuesToYaml(object)
+		if err != nil {
+			return options, cleanup, fmt.Errorf("invalid values_object: %w", err)
+		}
+		documents = append(documents, document)
+	}
+	for _, document := range documents {
+		if document == "" {
+			continue
+		}
+		f, err := os.CreateTemp("", ".values.*.yaml")
+		if err != nil {
+			return options, cleanup, err
+		}
+		options.ValueFiles = append(options.ValueFiles, f.Name())
+		if _, err := f.WriteString(document); err != nil {
+			f.Close()
+			return options, cleanup, err
+		}
+		if err := f.Close(); err != nil {
+			return options, cleanup, err
+		}
+	}
+
+	options.Values = ValueList(ctx, m.HelmSet)
+	if !m.ClusterId.IsNull() && !m.ClusterId.IsUnknown() {
+		options.Values = append(options.Values, fmt.Sprintf("cluster.id=%d", m.ClusterId.ValueInt64()))
+	}
+
+	var setString, setSensitive []CiliumInstallSetModel
+	if diags := m.SetString.ElementsAs(ctx, &setString, false); diags.HasError() {
+		return options, cleanup, fmt.Errorf("invalid set_string: %v", diags)
+	}
+	for _, e := range setString {
+		// Commas separate values for --set-string.
+		value := strings.NewReplacer(`\`, `\\`, ",", `\,`).Replace(e.Value.ValueString())
+		options.StringValues = append(options.StringValues, e.Name.ValueString()+"="+value)
+	}
+	if diags := m.SetSensitive.ElementsAs(ctx, &setSensitive, false); diags.HasError() {
+		return options, cleanup, fmt.Errorf("invalid set_sensitive: %v", diags)
+	}
+	for _, e := range setSensitive {
+		options.LiteralValues = append(options.LiteralValues, e.Name.ValueString()+"="+e.Value.ValueString())
+	}
+	if !m.ClusterName.IsNull() && !m.ClusterName.IsUnknown() {
+		options.StringValues = append(options.StringValues, "cluster.name="+m.ClusterName.ValueString())
+	}
+	return options, cleanup, nil
+}
+
+// sensitivePaths returns the paths of the set_sensitive values, which are
+// redacted from helm_values and helm_values_object.
+func (m *CiliumInstallResourceModel) sensitivePaths(ctx context.Context) []string {
+	var setSensitive []CiliumInstallSetModel
+	m.SetSensitive.ElementsAs(ctx, &setSensitive, false)
+	paths := []string{}
+	for _, e := range setSensitive {
+		paths = append(paths, e.Name.ValueString())
+	}
+	return paths
+}
+
+// chartParameters sets the chart of the installer. cilium-cli downloads the
+// charts of Helm repositories itself but only loads local charts from a
+// directory: a .tgz archive, a chart of an OCI registry or a verified chart is
+// written to a temporary directory, removed by the returned function.
+func (m *CiliumInstallResourceModel) chartParameters(params *install.Parameters) (func(), error) {
+	cleanup := func() {}
+	chartPath, version, repository := m.ChartPath.ValueString(), m.Version.ValueString(), m.Repository.ValueString()
+	verify := m.Verify.ValueBool()
+	params.HelmRepository = repository
+	if chartPath == "" && !registry.IsOCI(repository) && !verify {
+		return cleanup, nil
+	}
+	ciliumChart, err := LoadChart(chartPath, version, repository, verify, m.Keyring.ValueString())
+	if err != nil {
+		return cleanup, err
+	}
+	if strings.TrimPrefix(ciliumChart.Metadata.Version, "v") != strings.TrimPrefix(version, "v") {
+		return cleanup, fmt.Errorf("the chart is Cilium %s, not %s: set version to %s", ciliumChart.Metadata.Version, version, ciliumChart.Metadata.Version)
+	}
+	if info, err := os.Stat(chartPath); err == nil && info.IsDir() {
+		params.HelmChartDirectory = chartPath
+		return cleanup, nil
+	}
+	params.HelmChartDirectory, cleanup, err = ChartDirectory(ciliumChart)
+	return cleanup, err
+}
+
+// ModifyPlan verifies the chart before it is deployed when verify is set. On
+// upgrades, it computes the values of the release after the upgrade in
+// helm_values and renders the upgrade with the planned attributes to preview
+// the objects it changes in manifest_diff.
+func (r *CiliumInstallResource) ModifyPlan(ctx context.Context, req resource.ModifyPlanRequest, resp *resource.ModifyPlanResponse) {
+	// Nothing to check on destruction or without change.
+	if req.Plan.Raw.IsNull() || req.Plan.Raw.Equal(req.State.Raw) {
+		return
+	}
+	var data CiliumInstallResourceModel
+
+	// Read Terraform plan data into the model
+	resp.Diagnostics.Append(req.Plan.Get(ctx, &data)...)
+
+	if resp.Diagnostics.HasError() {
+		return
+	}
+	known := func(values ...attr.Value) bool {
+		for _, v := range values {
+			if tfValue, err := v.ToTerraformValue(ctx); err != nil || !tfValue.IsFullyKnown() {
+				return false
 			}
 		}
+		return true
+	}
+
+	var params = install.Parameters{}
+	verify := data.Verify.ValueBool()
+	if verify && known(data.Version, data.Repository, data.ChartPath, data.Keyring) {
+		chartCleanup, err := data.chartParameters(&params)
+		defer chartCleanup()
+		if err != nil {
+			resp.Diagnostics.AddError("Chart Verification Error", fmt.Sprintf("Unable to verify Cilium chart: %s", err))
+			return
+		}
+	}
+
+	// Cluster IDs above 255 need the larger cluster identity of Cilium.
+	if data.ClusterId.ValueInt64() > defaults.ClustermeshMaxConnectedClusters && known(data.Values, data.ValuesObject, data.HelmSet, data.SetString, data.SetSensitive) {
+		options, cleanup, err := data.helmOptions(ctx)
+		defer cleanup()
+		if err != nil {
+			resp.Diagnostics.AddError("Invalid Values", err.Error())
+			return
+		}
+		values, err := options.MergeValues(getter.All(cli.New()))
+		if err != nil {
+			resp.Diagnostics.AddError("Invalid Values", err.Error())
+			return
+		}
+		if maxConnectedClusters, _ := GetValue(values, "clustermesh.maxConnectedClusters"); fmt.Sprint(maxConnectedClusters) != "511" {
+			resp.Diagnostics.AddAttributeError(path.Root("cluster_id"), "Invalid Cluster ID", fmt.Sprintf("cluster_id %d is above %d: set clustermesh.maxConnectedClusters to 511", data.ClusterId.ValueInt64(), defaults.ClustermeshMaxConnectedClusters))
+			return
+		}
+	}
+
+	// Nothing to preview on creation or on replacement.
+	if req.State.Raw.IsNull() || len(resp.RequiresReplace) > 0 {
+		return
+	}
+	c := r.client.ForRelease(data.Namespace, data.HelmRelease)
+	if c == nil {
+		return
+	}
+	if _, err := c.K8sClient(); err != nil {
+		// The preview is left unknown until the cluster can be reached.
+		return
+	}
+	if !known(data.Version, data.Repository, data.ChartPath, data.Verify, data.Keyring, data.Values, data.ValuesObject, data.HelmSet, data.SetString, data.SetSensitive, data.ClusterName, data.ClusterId) {
+		return
+	}
+
+	readTimeout, diags := data.Timeouts.Read(ctx, defaults.StatusWaitDuration)
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+	ctx, cancel := context.WithTimeout(ctx, readTimeout)
+	defer cancel()
+
+	params.Namespace = c.namespace
+	params.Version = data.Version.ValueString()
+	params.HelmReleaseName = c.helm_release
+	params.HelmResetValues = data.Reset.ValueBool()
+	params.HelmReuseValues = data.Reuse.ValueBool()
+	params.HelmResetThenReuseValues = data.ResetThenReuse.ValueBool()
+
+	options, cleanup, err := data.helmOptions(ctx)
+	if err != nil {
+		resp.Diagnostics.AddError("Invalid Values", err.Error())
+		return
+	}
+	defer cleanup()
+	values, err := options.MergeValues(getter.All(cli.New()))
+	if err != nil {
+		resp.Diagnostics.AddError("Invalid Values", err.Error())
+		return
+	}
+
+	snapshot, err := c.GetReleaseSnapshot()
+	if err != nil {
+		resp.Diagnostics.AddWarning("Cilium Upgrade Preview", fmt.Sprintf("Unable to read Cilium release: %s", interrupted(ctx, err)))
+		return
+	}
+
+	planned, err := c.UpgradeValues(params, values)
+	if err != nil {
+		resp.Diagnostics.AddWarning("Cilium Upgrade Preview", fmt.Sprintf("Unable to compute the values of Cilium: %s", err))
+		return
+	}
+	// The values of set_sensitive are hidden from the plan.
+	redacted := RedactValues(planned, data.sensitivePaths(ctx))
+	helm_values, err := releaseValues(redacted)
+	if err != nil {
+		resp.Diagnostics.AddWarning("Cilium Upgrade Preview", fmt.Sprintf("Unable to compute the values of Cilium: %s", err))
+		return
+	}
+	helm_values_object, err := valuesObject(redacted)
+	if err != nil {
+		resp.Diagnostics.AddWarning("Cilium Upgrade Preview", fmt.Sprintf("Unable to compute the values of Cilium: %s", err))
+		return
+	}
+	resp.Diagnostics.Append(resp.Plan.SetAttribute(ctx, path.Root("helm_values"), helm_values)...)
+	resp.Diagnostics.Append(resp.Plan.SetAttribute(ctx, path.Root("helm_values_object"), helm_values_object)...)
+
+	// UpgradeValues may have merged the values of the release into values.
+	values, err = options.MergeValues(getter.All(cli.New()))
+	if err != nil {
+		resp.Diagnostics.AddError("Invalid Values", err.Error())
+		return
+	}
+	// A verified chart is already loaded.
+	if !verify {
+		chartCleanup, err := data.chartParameters(&params)
+		if err != nil {
+			resp.Diagnostics.AddWarning("Cilium Upgrade Preview", fmt.Sprintf("Unable to load Cilium chart: %s", interrupted(ctx, err)))
+			return
+		}
+		defer chartCleanup()
+	}
+	upgrade, err := c.DryRunUpgrade(ctx, params, values)
+	if err != nil {
+		resp.Diagnostics.AddWarning("Cilium Upgrade Preview", fmt.Sprintf("Unable to render the upgrade of Cilium: %s", interrupted(ctx, err)))
+		return
+	}
+	diff, err := ManifestDiff(snapshot.Release.Manifest, upgrade.Manifest)
+	if err != nil {
+		resp.Diagnostics.AddWarning("Cilium Upgrade Preview", fmt.Sprintf("Unable to compare the manifests of Cilium: %s", err))
+		return
 	}
-	if err := uninstaller.UninstallWithHelm(ctxb, k8sClient.HelmActionConfig); err != nil {
-		resp.Diagnostics.AddError("Client Error", fmt.Sprintf("⚠ ️ Unable to uninstall Cilium: %s", err))
+	resp.Diagnostics.Append(resp.Plan.SetAttribute(ctx, path.Root("manifest_diff"), diff)...)
+}
+
+// rollback rolls a failed upgrade back to the previous revision of the release.
+// The rollback runs even if the upgrade timed out.
+func (r *CiliumInstallResource) rollback(ctx context.Context, c *CiliumClient, previous *release.Release, resp *resource.UpdateResponse) {
+	ctx = context.WithoutCancel(ctx)
+	rolledBack, err := c.RollbackFailedUpgrade(ctx, previous)
+	if err != nil {
+		resp.Diagnostics.AddError("Client Error", fmt.Sprintf("Unable to roll back Cilium to revision %d: %s", previous.Version, err))
 		return
 	}
+	if rolledBack {
+		resp.Diagnostics.AddWarning("Cilium Rolled Back", fmt.Sprintf("The upgrade failed, Cilium was rolled back to revision %d", previous.Version))
+	}
 }
 
+// ImportState imports a release installed by `cilium install`, `helm install`
+// or another cilium resource. The id is `namespace/release` or the name of the
+// release. The values, the version and the datapath mode are taken from the
+// release and the other arguments get their defaults, so that the generated
+// configuration manages the release as it is.
 func (r *CiliumInstallResource) ImportState(ctx context.Context, req resource.ImportStateRequest, resp *resource.ImportStateResponse) {
-	resource.ImportStatePassthroughID(ctx, path.Root("id"), req, resp)
+	if r.client == nil {
+		resp.Diagnostics.AddError("Client Error", "Unable to connect to kubernetes")
+		return
+	}
+	// The namespace and the helm_release of the provider are left null.
+	namespace, helm_release := types.StringNull(), types.StringValue(req.ID)
+	if before, after, found := strings.Cut(req.ID, "/"); found {
+		namespace, helm_release = types.StringValue(before), types.StringValue(after)
+	}
+	if namespace.ValueString() == r.client.namespace {
+		namespace = types.StringNull()
+	}
+	if helm_release.ValueString() == r.client.helm_release {
+		helm_release = types.StringNull()
+	}
+	c := r.client.ForRelease(namespace, helm_release)
+	if _, err := c.K8sClient(); err != nil {
+		resp.Diagnostics.AddError("Client Error", fmt.Sprintf("Unable to connect to kubernetes: %s", err))
+		return
+	}
+
+	snapshot, err := c.GetReleaseSnapshot()
+	if err != nil {
+		resp.Diagnostics.AddError("Client Error", fmt.Sprintf("Unable to read Cilium release %s/%s: %s", c.namespace, c.helm_release, err))
+		return
+	}
+	values := ""
+	if len(snapshot.Release.Config) > 0 {
+		if values, err = snapshot.Values(); err != nil {
+			resp.Diagnostics.AddError("Client Error", fmt.Sprintf("Unable to read Cilium values: %s", err))
+			return
+		}
+	}
+
+	for name, value := range map[string]attr.Value{
+		"id":                types.StringValue(c.helm_release),
+		"namespace":         namespace,
+		"helm_release":      helm_release,
+		"values":            NewValuesValue(values),
+		"version":           types.StringValue(snapshot.Version()),
+		"data_path":         types.StringValue(snapshot.DatapathMode()),
+		"repository":        types.StringValue(defaults.HelmRepository),
+		"set":               types.ListNull(types.StringType),
+		"reuse":             types.BoolValue(false),
+		"reset":             types.BoolValue(false),
+		"reusethenreuse":    types.BoolValue(true),
+		"recover_pending":   types.BoolValue(false),
+		"atomic":            types.BoolValue(false),
+		"verify":            types.BoolValue(false),
+		"wait":              types.BoolValue(true),
+		"detected_platform": types.StringValue(c.DetectPlatform(ctx)),
+	} {
+		resp.Diagnostics.Append(resp.State.SetAttribute(ctx, path.Root(name), value)...)
+	}
 }
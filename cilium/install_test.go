@@ -4,9 +4,32 @@
 package provider
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
+	"net/http"
+	"os"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/cilium/cilium/cilium-cli/defaults"
+	"github.com/cilium/cilium/cilium-cli/install"
+	"gopkg.in/yaml.v3"
+	"helm.sh/helm/v3/pkg/chart/loader"
+	"helm.sh/helm/v3/pkg/cli"
+	"helm.sh/helm/v3/pkg/getter"
+	"helm.sh/helm/v3/pkg/release"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/hashicorp/terraform-plugin-framework/attr"
+	fwresource "github.com/hashicorp/terraform-plugin-framework/resource"
+	"github.com/hashicorp/terraform-plugin-framework/resource/schema"
+	"github.com/hashicorp/terraform-plugin-framework/resource/schema/planmodifier"
+	"github.com/hashicorp/terraform-plugin-framework/tfsdk"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+	"github.com/hashicorp/terraform-plugin-go/tftypes"
 	"github.com/hashicorp/terraform-plugin-testing/helper/resource"
 )
 
@@ -25,10 +48,10 @@ func TestAccCiliumInstallResource(t *testing.T) {
 			},
 			// ImportState testing
 			{
-				ResourceName:            "cilium.test",
-				ImportState:             true,
-				ImportStateVerify:       true,
-				ImportStateVerifyIgnore: []string{"data_path", "repository", "reset", "reuse", "values", "wait"},
+				ResourceName:      "cilium.test",
+				ImportState:       true,
+				ImportStateId:     "kube-system/cilium",
+				ImportStateVerify: true,
 			},
 			// Update and Read testing
 			{
@@ -36,6 +59,8 @@ func TestAccCiliumInstallResource(t *testing.T) {
 				Check: resource.ComposeAggregateTestCheckFunc(
 					resource.TestCheckResourceAttr("cilium.test", "version", "1.15.8"),
 					resource.TestCheckResourceAttr("cilium.test", "id", "cilium"),
+					resource.TestCheckResourceAttr("cilium.test", "revision", "2"),
+					resource.TestCheckResourceAttr("cilium.test", "status", "deployed"),
 				),
 			},
 			// Delete testing automatically occurs in TestCase
@@ -50,3 +75,479 @@ resource "cilium" "test" {
 }
 `, version)
 }
+
+// An interrupted uninstall must not wait for the test namespace forever.
+func TestCiliumInstallResourceDeleteInterrupted(t *testing.T) {
+	srv := testFakeCluster(t, "kind-test", nil)
+	r := NewCiliumInstallResource()
+	testResourceClient(t, r, srv, nil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(200*time.Millisecond, cancel)
+
+	start := time.Now()
+	resp := &fwresource.DeleteResponse{}
+	r.Delete(ctx, fwresource.DeleteRequest{
+		State: testResourceState(t, r, map[string]tftypes.Value{
+			"wait": tftypes.NewValue(tftypes.Bool, true),
+		}),
+	}, resp)
+
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Delete returned after %s", elapsed)
+	}
+	if !resp.Diagnostics.HasError() {
+		t.Fatal("expected an error")
+	}
+	if detail := resp.Diagnostics.Errors()[0].Detail(); !strings.Contains(detail, "operation interrupted") {
+		t.Errorf("unexpected error: %s", detail)
+	}
+}
+
+// A missing release means that Cilium was uninstalled outside of Terraform.
+func TestCiliumInstallResourceReadReleaseNotFound(t *testing.T) {
+	srv := testFakeCluster(t, "kind-test", nil)
+	r := NewCiliumInstallResource()
+	testResourceClient(t, r, srv, map[string]tftypes.Value{
+		"helm_driver": tftypes.NewValue(tftypes.String, "memory"),
+	})
+
+	state := testResourceState(t, r, map[string]tftypes.Value{
+		"version": tftypes.NewValue(tftypes.String, "1.17.3"),
+	})
+	resp := &fwresource.ReadResponse{State: state}
+	r.Read(context.Background(), fwresource.ReadRequest{State: state}, resp)
+
+	if resp.Diagnostics.HasError() {
+		t.Fatalf("unexpected diagnostics: %v", resp.Diagnostics)
+	}
+	if !resp.State.Raw.IsNull() {
+		t.Error("the resource should be removed")
+	}
+}
+
+// A flaky API server must not plan to install Cilium again.
+func TestCiliumInstallResourceReadTransientError(t *testing.T) {
+	srv := testFakeCluster(t, "kind-test", map[string]http.HandlerFunc{
+		"/api/v1/namespaces/kube-system/secrets": testStatusError(http.StatusServiceUnavailable, "etcdserver: request timed out"),
+	})
+	r := NewCiliumInstallResource()
+	testResourceClient(t, r, srv, nil)
+
+	state := testResourceState(t, r, map[string]tftypes.Value{
+		"version": tftypes.NewValue(tftypes.String, "1.17.3"),
+	})
+	resp := &fwresource.ReadResponse{State: state}
+	r.Read(context.Background(), fwresource.ReadRequest{State: state}, resp)
+
+	if !resp.Diagnostics.HasError() {
+		t.Fatal("expected an error")
+	}
+	if detail := resp.Diagnostics.Errors()[0].Detail(); !strings.Contains(detail, "etcdserver: request timed out") {
+		t.Errorf("unexpected error: %s", detail)
+	}
+	if resp.State.Raw.IsNull() {
+		t.Error("the resource should be kept")
+	}
+}
+
+// The values are merged like cilium-cli merges its flags.
+func TestCiliumInstallResourceHelmOptions(t *testing.T) {
+	ctx := context.Background()
+	setType := types.ObjectType{AttrTypes: map[string]attr.Type{"name": types.StringType, "value": types.StringType}}
+	setEntry := func(name, value string) attr.Value {
+		return types.ObjectValueMust(setType.AttrTypes, map[string]attr.Value{"name": types.StringValue(name), "value": types.StringValue(value)})
+	}
+	data := CiliumInstallResourceModel{
+		Values: NewValuesValue("ipam:\n  mode: cluster-pool\noperator:\n  replicas: 2\n"),
+		ValuesObject: types.DynamicValue(types.ObjectValueMust(
+			map[string]attr.Type{"ipam": types.ObjectType{AttrTypes: map[string]attr.Type{"mode": types.StringType}}},
+			map[string]attr.Value{"ipam": types.ObjectValueMust(map[string]attr.Type{"mode": types.StringType}, map[string]attr.Value{"mode": types.StringValue("kubernetes")})},
+		)),
+		HelmSet:      types.ListValueMust(types.StringType, []attr.Value{types.StringValue("operator.replicas=1")}),
+		SetString:    types.ListValueMust(setType, []attr.Value{setEntry("cluster.id", "1"), setEntry("cluster.name", "a,b")}),
+		SetSensitive: types.ListValueMust(setType, []attr.Value{setEntry("hubble.tls.server.key", "x=y,z")}),
+	}
+
+	options, cleanup, err := data.helmOptions(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cleanup()
+	values, err := options.MergeValues(getter.All(cli.New()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	yaml, err := ValuesToYaml(values)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "cluster:\n    id: \"1\"\n    name: a,b\nhubble:\n    tls:\n        server:\n            key: x=y,z\nipam:\n    mode: kubernetes\noperator:\n    replicas: 1\n"
+	if yaml != expected {
+		t.Errorf("got:\n%s\nexpected:\n%s", yaml, expected)
+	}
+
+	files := options.ValueFiles
+	cleanup()
+	for _, f := range files {
+		if _, err := os.Stat(f); !os.IsNotExist(err) {
+			t.Errorf("%s was not removed", f)
+		}
+	}
+}
+
+// A local chart or an OCI chart is passed to cilium-cli as a directory.
+func TestCiliumInstallResourceChartParameters(t *testing.T) {
+	archivePath := testChartArchive(t, t.TempDir(), "1.18.0")
+	archive, err := os.ReadFile(archivePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	host := testOCIRegistry(t, archive, nil, "1.18.0")
+
+	for name, data := range map[string]CiliumInstallResourceModel{
+		"directory": {ChartPath: types.StringValue("testdata/chart/cilium"), Version: types.StringValue("1.18.0"), Repository: types.StringValue(defaults.HelmRepository)},
+		"archive":   {ChartPath: types.StringValue(archivePath), Version: types.StringValue("1.18.0"), Repository: types.StringValue(defaults.HelmRepository)},
+		"oci":       {ChartPath: types.StringNull(), Version: types.StringValue("1.18.0"), Repository: types.StringValue("oci://" + host + "/charts/cilium")},
+	} {
+		var params install.Parameters
+		cleanup, err := data.chartParameters(&params)
+		if err != nil {
+			t.Errorf("%s: %s", name, err)
+			continue
+		}
+		if params.HelmRepository != data.Repository.ValueString() {
+			t.Errorf("%s: got repository %q", name, params.HelmRepository)
+		}
+		ciliumChart, err := loader.LoadDir(params.HelmChartDirectory)
+		if err != nil {
+			t.Errorf("%s: %s", name, err)
+		} else if ciliumChart.Metadata.Version != "1.18.0" {
+			t.Errorf("%s: got chart %s", name, ciliumChart.Metadata.Version)
+		}
+		cleanup()
+		if name != "directory" {
+			if _, err := os.Stat(params.HelmChartDirectory); !os.IsNotExist(err) {
+				t.Errorf("%s: %s was not removed", name, params.HelmChartDirectory)
+			}
+		}
+	}
+
+	// The charts of Helm repositories are left to cilium-cli.
+	data := CiliumInstallResourceModel{ChartPath: types.StringNull(), Version: types.StringValue("1.17.3"), Repository: types.StringValue(defaults.HelmRepository)}
+	var params install.Parameters
+	if _, err := data.chartParameters(&params); err != nil || params.HelmChartDirectory != "" || params.HelmRepository != defaults.HelmRepository {
+		t.Errorf("got %q, %q, %v", params.HelmChartDirectory, params.HelmRepository, err)
+	}
+
+	data = CiliumInstallResourceModel{ChartPath: types.StringValue("testdata/chart/cilium"), Version: types.StringValue("1.17.3"), Repository: types.StringValue(defaults.HelmRepository)}
+	if _, err := data.chartParameters(&params); err == nil || !strings.Contains(err.Error(), "set version to 1.18.0") {
+		t.Errorf("expected a version mismatch error, got %v", err)
+	}
+}
+
+// A chart which does not match its provenance file fails the plan.
+func TestCiliumInstallResourceModifyPlanVerify(t *testing.T) {
+	archivePath := testChartArchive(t, t.TempDir(), "1.18.0")
+	keyring := testSignChart(t, archivePath)
+	otherKeyring := testSignChart(t, testChartArchive(t, t.TempDir(), "1.18.0"))
+
+	r := &CiliumInstallResource{}
+	for k, expectError := range map[string]bool{keyring: false, otherKeyring: true} {
+		plan := testResourcePlan(t, r, map[string]tftypes.Value{
+			"version":    tftypes.NewValue(tftypes.String, "1.18.0"),
+			"repository": tftypes.NewValue(tftypes.String, defaults.HelmRepository),
+			"chart_path": tftypes.NewValue(tftypes.String, archivePath),
+			"verify":     tftypes.NewValue(tftypes.Bool, true),
+			"keyring":    tftypes.NewValue(tftypes.String, k),
+		})
+		state := tfsdk.State{Schema: plan.Schema, Raw: tftypes.NewValue(plan.Raw.Type(), nil)}
+		resp := &fwresource.ModifyPlanResponse{Plan: plan}
+		r.ModifyPlan(context.Background(), fwresource.ModifyPlanRequest{Plan: plan, State: state}, resp)
+		if resp.Diagnostics.HasError() != expectError {
+			t.Errorf("keyring %s: unexpected diagnostics: %v", k, resp.Diagnostics)
+		}
+	}
+}
+
+// cluster_name and cluster_id override the values and are read back from the
+// release. IDs above 255 need clustermesh.maxConnectedClusters set to 511.
+func TestCiliumInstallResourceClusterIdentity(t *testing.T) {
+	ctx := context.Background()
+	setType := types.ObjectType{AttrTypes: map[string]attr.Type{"name": types.StringType, "value": types.StringType}}
+	data := CiliumInstallResourceModel{
+		HelmSet:      types.ListValueMust(types.StringType, []attr.Value{types.StringValue("cluster.name=other"), types.StringValue("cluster.id=7")}),
+		SetString:    types.ListNull(setType),
+		SetSensitive: types.ListNull(setType),
+		ClusterName:  types.StringValue("mesh-1"),
+		ClusterId:    types.Int64Value(3),
+	}
+	options, cleanup, err := data.helmOptions(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cleanup()
+	values, err := options.MergeValues(getter.All(cli.New()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	snapshot := &ReleaseSnapshot{Release: &release.Release{Config: values}}
+	if name, id := snapshot.ClusterIdentity(); name.ValueString() != "mesh-1" || id.ValueInt64() != 3 {
+		t.Errorf("got cluster %s, id %s", name, id)
+	}
+	snapshot = &ReleaseSnapshot{Release: &release.Release{Config: map[string]interface{}{}}}
+	if name, id := snapshot.ClusterIdentity(); !name.IsNull() || !id.IsNull() {
+		t.Errorf("got cluster %s, id %s", name, id)
+	}
+
+	r := &CiliumInstallResource{}
+	for maxConnectedClusters, expectError := range map[string]bool{"": true, "clustermesh:\n  maxConnectedClusters: 511\n": false} {
+		plan := testResourcePlan(t, r, map[string]tftypes.Value{
+			"values":     tftypes.NewValue(tftypes.String, maxConnectedClusters),
+			"cluster_id": tftypes.NewValue(tftypes.Number, 300),
+		})
+		state := tfsdk.State{Schema: plan.Schema, Raw: tftypes.NewValue(plan.Raw.Type(), nil)}
+		resp := &fwresource.ModifyPlanResponse{Plan: plan}
+		r.ModifyPlan(ctx, fwresource.ModifyPlanRequest{Plan: plan, State: state}, resp)
+		if resp.Diagnostics.HasError() != expectError {
+			t.Errorf("values %q: unexpected diagnostics: %v", maxConnectedClusters, resp.Diagnostics)
+		}
+	}
+}
+
+// Changing data_path replaces the resource, unless it is removed or set to the
+// detected datapath mode.
+func TestCiliumInstallResourceDataPathRequiresReplace(t *testing.T) {
+	r := NewCiliumInstallResource()
+	schemaResp := &fwresource.SchemaResponse{}
+	r.Schema(context.Background(), fwresource.SchemaRequest{}, schemaResp)
+	dataPath := schemaResp.Schema.Attributes["data_path"].(schema.StringAttribute)
+	state := testResourceState(t, r, map[string]tftypes.Value{
+		"data_path":          tftypes.NewValue(tftypes.String, ""),
+		"detected_data_path": tftypes.NewValue(tftypes.String, "tunnel"),
+	})
+	for _, tc := range []struct {
+		state, plan string
+		replace     bool
+	}{
+		{"", "tunnel", false},
+		{"", "native", true},
+		{"native", "", false},
+		{"native", "aws-eni", true},
+	} {
+		req := planmodifier.StringRequest{
+			State:       state,
+			Plan:        tfsdk.Plan{Schema: state.Schema, Raw: state.Raw},
+			ConfigValue: types.StringValue(tc.plan),
+			StateValue:  types.StringValue(tc.state),
+			PlanValue:   types.StringValue(tc.plan),
+		}
+		resp := &planmodifier.StringResponse{PlanValue: req.PlanValue}
+		for _, modifier := range dataPath.PlanModifiers {
+			modifier.PlanModifyString(context.Background(), req, resp)
+		}
+		if resp.Diagnostics.HasError() {
+			t.Fatalf("unexpected diagnostics: %v", resp.Diagnostics)
+		}
+		if resp.RequiresReplace != tc.replace {
+			t.Errorf("%q -> %q: got requires replace %t", tc.state, tc.plan, resp.RequiresReplace)
+		}
+	}
+
+	// Without data_path, the imported datapath mode is kept.
+	req := planmodifier.StringRequest{
+		State:       state,
+		Plan:        tfsdk.Plan{Schema: state.Schema, Raw: state.Raw},
+		ConfigValue: types.StringNull(),
+		StateValue:  types.StringValue("tunnel"),
+		PlanValue:   types.StringUnknown(),
+	}
+	resp := &planmodifier.StringResponse{PlanValue: req.PlanValue}
+	for _, modifier := range dataPath.PlanModifiers {
+		modifier.PlanModifyString(context.Background(), req, resp)
+	}
+	if resp.RequiresReplace || resp.PlanValue.ValueString() != "tunnel" {
+		t.Errorf("got plan %s, requires replace %t", resp.PlanValue, resp.RequiresReplace)
+	}
+}
+
+// The set_sensitive values are redacted from the state and from the plan.
+func TestCiliumInstallResourceSetSensitiveRedacted(t *testing.T) {
+	const secret = "s3cr3t-key"
+	srv := testFakeCluster(t, "kind-test", map[string]http.HandlerFunc{
+		"/api/v1/namespaces/kube-system/secrets/cilium-ca": testObject(corev1.Secret{
+			TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "Secret"},
+			ObjectMeta: metav1.ObjectMeta{Name: "cilium-ca", Namespace: "kube-system"},
+		}),
+	})
+	r := NewCiliumInstallResource()
+	c := testResourceClient(t, r, srv, map[string]tftypes.Value{
+		"helm_driver": tftypes.NewValue(tftypes.String, "memory"),
+	})
+	testCreateRelease(t, c, map[string]interface{}{
+		"hubble": map[string]interface{}{"tls": map[string]interface{}{"server": map[string]interface{}{"key": secret}}},
+	})
+
+	setType := tftypes.Object{AttributeTypes: map[string]tftypes.Type{"name": tftypes.String, "value": tftypes.String}}
+	attributes := map[string]tftypes.Value{
+		"version": tftypes.NewValue(tftypes.String, "1.17.3"),
+		"set_sensitive": tftypes.NewValue(tftypes.List{ElementType: setType}, []tftypes.Value{
+			tftypes.NewValue(setType, map[string]tftypes.Value{
+				"name":  tftypes.NewValue(tftypes.String, "hubble.tls.server.key"),
+				"value": tftypes.NewValue(tftypes.String, secret),
+			}),
+		}),
+	}
+	state := testResourceState(t, r, attributes)
+	readResp := &fwresource.ReadResponse{State: state}
+	r.Read(context.Background(), fwresource.ReadRequest{State: state}, readResp)
+	if readResp.Diagnostics.HasError() {
+		t.Fatalf("unexpected diagnostics: %v", readResp.Diagnostics)
+	}
+	var data CiliumInstallResourceModel
+	readResp.Diagnostics.Append(readResp.State.Get(context.Background(), &data)...)
+	if strings.Contains(data.HelmValues.ValueString(), secret) || strings.Contains(fmt.Sprint(data.HelmValuesObject), secret) {
+		t.Errorf("the secret is in the state: %s %s", data.HelmValues, data.HelmValuesObject)
+	}
+	if !strings.Contains(data.HelmValues.ValueString(), sensitiveValue) {
+		t.Errorf("got helm_values %s", data.HelmValues)
+	}
+
+	attributes["version"] = tftypes.NewValue(tftypes.String, "1.17.4")
+	attributes["repository"] = tftypes.NewValue(tftypes.String, defaults.HelmRepository)
+	attributes["reusethenreuse"] = tftypes.NewValue(tftypes.Bool, true)
+	plan := testResourcePlan(t, r, attributes)
+	planResp := &fwresource.ModifyPlanResponse{Plan: plan}
+	r.(fwresource.ResourceWithModifyPlan).ModifyPlan(context.Background(), fwresource.ModifyPlanRequest{Plan: plan, State: readResp.State}, planResp)
+	if planResp.Diagnostics.HasError() {
+		t.Fatalf("unexpected diagnostics: %v", planResp.Diagnostics)
+	}
+	planResp.Diagnostics.Append(planResp.Plan.Get(context.Background(), &data)...)
+	if !strings.Contains(data.HelmValues.ValueString(), sensitiveValue) || strings.Contains(data.HelmValues.ValueString(), secret) || strings.Contains(fmt.Sprint(data.HelmValuesObject), secret) {
+		t.Errorf("the secret is in the plan: %s %s", data.HelmValues, data.HelmValuesObject)
+	}
+}
+
+// The numbers of set are planned as integers but applied as float64, like the
+// releases stored by Helm: helm_values must not be inconsistent after apply.
+func TestCiliumInstallResourceModifyPlanNumericValues(t *testing.T) {
+	srv := testFakeCluster(t, "kind-test", map[string]http.HandlerFunc{
+		"/api/v1/namespaces/kube-system/secrets/cilium-ca": testObject(corev1.Secret{
+			TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "Secret"},
+			ObjectMeta: metav1.ObjectMeta{Name: "cilium-ca", Namespace: "kube-system"},
+		}),
+	})
+	r := NewCiliumInstallResource()
+	c := testResourceClient(t, r, srv, map[string]tftypes.Value{
+		"helm_driver": tftypes.NewValue(tftypes.String, "memory"),
+	})
+	testCreateRelease(t, c, map[string]interface{}{
+		"clustermesh": map[string]interface{}{"maxConnectedClusters": float64(255)},
+	})
+
+	attributes := map[string]tftypes.Value{
+		"version":    tftypes.NewValue(tftypes.String, "1.17.3"),
+		"repository": tftypes.NewValue(tftypes.String, defaults.HelmRepository),
+		"set": tftypes.NewValue(tftypes.List{ElementType: tftypes.String}, []tftypes.Value{
+			tftypes.NewValue(tftypes.String, "bpf.mapDynamicSizeRatio=0.0025"),
+			tftypes.NewValue(tftypes.String, "clustermesh.maxConnectedClusters=511"),
+			tftypes.NewValue(tftypes.String, "bpf.natMax=1048576"),
+		}),
+		"reusethenreuse": tftypes.NewValue(tftypes.Bool, true),
+	}
+	plan := testResourcePlan(t, r, attributes)
+	attributes["set"] = tftypes.NewValue(tftypes.List{ElementType: tftypes.String}, nil)
+	state := testResourceState(t, r, attributes)
+	resp := &fwresource.ModifyPlanResponse{Plan: plan}
+	r.(fwresource.ResourceWithModifyPlan).ModifyPlan(context.Background(), fwresource.ModifyPlanRequest{Plan: plan, State: state}, resp)
+	if resp.Diagnostics.HasError() {
+		t.Fatalf("unexpected diagnostics: %v", resp.Diagnostics)
+	}
+	var data CiliumInstallResourceModel
+	resp.Diagnostics.Append(resp.Plan.Get(context.Background(), &data)...)
+	if !strings.Contains(data.HelmValues.ValueString(), "maxConnectedClusters: 511") || !strings.Contains(data.HelmValues.ValueString(), "natMax: 1048576") {
+		t.Fatalf("got helm_values %s: %v", data.HelmValues, resp.Diagnostics)
+	}
+
+	var planned map[string]interface{}
+	if err := yaml.Unmarshal([]byte(data.HelmValues.ValueString()), &planned); err != nil {
+		t.Fatal(err)
+	}
+	stored, err := json.Marshal(planned)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var config map[string]interface{}
+	if err := json.Unmarshal(stored, &config); err != nil {
+		t.Fatal(err)
+	}
+	applied, err := releaseValues(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	equal, diags := data.HelmValues.StringSemanticEquals(context.Background(), NewValuesValue(applied))
+	if diags.HasError() || !equal {
+		t.Errorf("the planned values differ from the applied ones:\n%s\n%s", data.HelmValues.ValueString(), applied)
+	}
+}
+
+// The arguments of an imported release are taken from the release.
+func TestCiliumInstallResourceImportState(t *testing.T) {
+	srv := testFakeCluster(t, "kind-test", nil)
+	r := NewCiliumInstallResource()
+	c := testResourceClient(t, r, srv, map[string]tftypes.Value{
+		"helm_driver": tftypes.NewValue(tftypes.String, "memory"),
+	})
+	testCreateRelease(t, c, map[string]interface{}{
+		"cluster":        map[string]interface{}{"name": "kind-test"},
+		"routingMode":    "tunnel",
+		"tunnelProtocol": "vxlan",
+	})
+
+	for _, id := range []string{"kube-system/cilium", "cilium"} {
+		resp := &fwresource.ImportStateResponse{State: testResourceState(t, r, nil)}
+		r.(fwresource.ResourceWithImportState).ImportState(context.Background(), fwresource.ImportStateRequest{ID: id}, resp)
+		if resp.Diagnostics.HasError() {
+			t.Fatalf("%s: unexpected diagnostics: %v", id, resp.Diagnostics)
+		}
+		var data CiliumInstallResourceModel
+		resp.Diagnostics.Append(resp.State.Get(context.Background(), &data)...)
+		if resp.Diagnostics.HasError() {
+			t.Fatalf("%s: unexpected diagnostics: %v", id, resp.Diagnostics)
+		}
+		if data.Id.ValueString() != "cilium" || data.Version.ValueString() != "1.17.3" || data.DataPath.ValueString() != "tunnel" {
+			t.Errorf("%s: got id %s, version %s, data_path %s", id, data.Id, data.Version, data.DataPath)
+		}
+		if !SameValues(data.Values.ValueString(), "cluster:\n  name: kind-test\nroutingMode: tunnel\ntunnelProtocol: vxlan\n") {
+			t.Errorf("%s: got values:\n%s", id, data.Values.ValueString())
+		}
+		if data.DetectedPlatform.ValueString() != "kind" {
+			t.Errorf("%s: got detected_platform %s", id, data.DetectedPlatform)
+		}
+		if data.Repository.ValueString() != defaults.HelmRepository || !data.Wait.ValueBool() || !data.ResetThenReuse.ValueBool() || data.Atomic.ValueBool() {
+			t.Errorf("%s: the other arguments should get their defaults", id)
+		}
+	}
+
+	resp := &fwresource.ImportStateResponse{State: testResourceState(t, r, nil)}
+	r.(fwresource.ResourceWithImportState).ImportState(context.Background(), fwresource.ImportStateRequest{ID: "cilium-system/cilium"}, resp)
+	if !resp.Diagnostics.HasError() {
+		t.Error("expected an error for a missing release")
+	}
+
+	other := c.ForRelease(types.StringValue("cilium-system"), types.StringNull())
+	if _, err := other.K8sClient(); err != nil {
+		t.Fatal(err)
+	}
+	testCreateRelease(t, other, map[string]interface{}{"routingMode": "native"})
+	resp = &fwresource.ImportStateResponse{State: testResourceState(t, r, nil)}
+	r.(fwresource.ResourceWithImportState).ImportState(context.Background(), fwresource.ImportStateRequest{ID: "cilium-system/cilium"}, resp)
+	if resp.Diagnostics.HasError() {
+		t.Fatalf("unexpected diagnostics: %v", resp.Diagnostics)
+	}
+	var data CiliumInstallResourceModel
+	resp.Diagnostics.Append(resp.State.Get(context.Background(), &data)...)
+	if data.Namespace.ValueString() != "cilium-system" || !data.HelmRelease.IsNull() || data.DataPath.ValueString() != "native" {
+		t.Errorf("got namespace %s, helm_release %s, data_path %s", data.Namespace, data.HelmRelease, data.DataPath)
+	}
+}
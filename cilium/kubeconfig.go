@@ -0,0 +1,269 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package provider
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/cilium/cilium/cilium-cli/k8s"
+
+	"helm.sh/helm/v3/pkg/action"
+	"helm.sh/helm/v3/pkg/storage"
+	"helm.sh/helm/v3/pkg/storage/driver"
+	apiextensionsclientset "k8s.io/apiextensions-apiserver/pkg/client/clientset/clientset"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/discovery/cached/memory"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/restmapper"
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+
+	ciliumClientset "github.com/cilium/cilium/pkg/k8s/client/clientset/versioned"
+
+	"github.com/hashicorp/terraform-plugin-framework/types"
+)
+
+// Ensure restClientGetter can be used by Helm and cilium-cli.
+var _ genericclioptions.RESTClientGetter = &restClientGetter{}
+
+// CiliumProviderExecModel describes the exec credential plugin block.
+type CiliumProviderExecModel struct {
+	APIVersion types.String `tfsdk:"api_version"`
+	Command    types.String `tfsdk:"command"`
+	Args       types.List   `tfsdk:"args"`
+	Env        types.Map    `tfsdk:"env"`
+}
+
+// clientConfig builds the Kubernetes client configuration of the provider.
+// The connection attributes (host, token, certificates...) override the ones
+// found in the kubeconfig. Everything stays in memory: the configuration of a
+// provider never leaks into the process environment or to disk, so that
+// several provider aliases can live in the same plugin process.
+func (m *CiliumProviderModel) clientConfig(ctx context.Context) (clientcmd.ClientConfig, error) {
+	overrides := &clientcmd.ConfigOverrides{
+		CurrentContext: m.Context.ValueString(),
+	}
+	overrides.ClusterInfo.Server = m.Host.ValueString()
+	overrides.ClusterInfo.TLSServerName = m.TLSServerName.ValueString()
+	overrides.ClusterInfo.InsecureSkipTLSVerify = m.Insecure.ValueBool()
+	overrides.ClusterInfo.ProxyURL = m.ProxyURL.ValueString()
+	if ca := m.ClusterCACertificate.ValueString(); ca != "" {
+		overrides.ClusterInfo.CertificateAuthorityData = []byte(ca)
+	}
+	if cert := m.ClientCertificate.ValueString(); cert != "" {
+		overrides.AuthInfo.ClientCertificateData = []byte(cert)
+	}
+	if key := m.ClientKey.ValueString(); key != "" {
+		overrides.AuthInfo.ClientKeyData = []byte(key)
+	}
+	overrides.AuthInfo.Token = m.Token.ValueString()
+
+	// Impersonation is part of the client configuration so that it also
+	// applies to Helm, which builds its own clients from it.
+	overrides.AuthInfo.Impersonate = m.ImpersonateUser.ValueString()
+	overrides.AuthInfo.ImpersonateUID = m.ImpersonateUID.ValueString()
+	overrides.AuthInfo.ImpersonateGroups = ValueList(ctx, m.ImpersonateGroups)
+	if !m.ImpersonateExtra.IsNull() {
+		extra := map[string][]string{}
+		m.ImpersonateExtra.ElementsAs(ctx, &extra, false)
+		overrides.AuthInfo.ImpersonateUserExtra = extra
+	}
+
+	if m.Exec != nil {
+		exec := &clientcmdapi.ExecConfig{
+			APIVersion:      m.Exec.APIVersion.ValueString(),
+			Command:         m.Exec.Command.ValueString(),
+			Args:            ValueList(ctx, m.Exec.Args),
+			InteractiveMode: clientcmdapi.NeverExecInteractiveMode,
+		}
+		env := map[string]string{}
+		m.Exec.Env.ElementsAs(ctx, &env, false)
+		for k, v := range env {
+			exec.Env = append(exec.Env, clientcmdapi.ExecEnvVar{Name: k, Value: v})
+		}
+		overrides.AuthInfo.Exec = exec
+	}
+
+	if content := m.ConfigContent.ValueString(); content != "" {
+		kubeconfig, err := parseKubeConfig(content)
+		if err != nil {
+			return nil, err
+		}
+		return clientcmd.NewNonInteractiveClientConfig(*kubeconfig, overrides.CurrentContext, overrides, nil), nil
+	}
+
+	rules := clientcmd.NewDefaultClientConfigLoadingRules()
+	if configPath := m.ConfigPath.ValueString(); configPath != "" {
+		rules.ExplicitPath = configPath
+	} else if m.Host.ValueString() != "" {
+		// Don't mix the local kubeconfig with explicit credentials.
+		rules = &clientcmd.ClientConfigLoadingRules{}
+	}
+	return clientcmd.NewNonInteractiveDeferredLoadingClientConfig(rules, overrides), nil
+}
+
+// parseKubeConfig loads a kubeconfig given either in raw yaml or in base 64.
+func parseKubeConfig(content string) (*clientcmdapi.Config, error) {
+	raw := []byte(content)
+	if decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(content)); err == nil {
+		raw = decoded
+	}
+	kubeconfig, err := clientcmd.Load(raw)
+	if err != nil {
+		return nil, fmt.Errorf("unable to load kube config: %w", err)
+	}
+	return kubeconfig, nil
+}
+
+// restClientGetter exposes a client configuration to Helm and cilium-cli.
+// The discovery client and the REST mapper are shared by every Helm action of
+// the provider instead of being rebuilt for each of them.
+type restClientGetter struct {
+	clientConfig    clientcmd.ClientConfig
+	mutex           sync.Mutex
+	discoveryClient discovery.CachedDiscoveryInterface
+	restMapper      meta.RESTMapper
+}
+
+func (g *restClientGetter) ToRESTConfig() (*rest.Config, error) {
+	return g.clientConfig.ClientConfig()
+}
+
+func (g *restClientGetter) ToDiscoveryClient() (discovery.CachedDiscoveryInterface, error) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	return g.toDiscoveryClient()
+}
+
+func (g *restClientGetter) toDiscoveryClient() (discovery.CachedDiscoveryInterface, error) {
+	if g.discoveryClient != nil {
+		return g.discoveryClient, nil
+	}
+	config, err := g.ToRESTConfig()
+	if err != nil {
+		return nil, err
+	}
+	// Same burst as kubectl: discovery hits a lot of endpoints.
+	config.Burst = 300
+	discoveryClient, err := discovery.NewDiscoveryClientForConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	g.discoveryClient = memory.NewMemCacheClient(discoveryClient)
+	return g.discoveryClient, nil
+}
+
+func (g *restClientGetter) ToRESTMapper() (meta.RESTMapper, error) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	if g.restMapper != nil {
+		return g.restMapper, nil
+	}
+	discoveryClient, err := g.toDiscoveryClient()
+	if err != nil {
+		return nil, err
+	}
+	mapper := restmapper.NewDeferredDiscoveryRESTMapper(discoveryClient)
+	g.restMapper = restmapper.NewShortcutExpander(mapper, discoveryClient, nil)
+	return g.restMapper, nil
+}
+
+func (g *restClientGetter) ToRawKubeConfigLoader() clientcmd.ClientConfig {
+	return g.clientConfig
+}
+
+// newK8sClient is the equivalent of k8s.NewClient for a client configuration
+// which doesn't come from a kubeconfig file.
+// Helm releases are stored with helmDriver in helmNamespace.
+func newK8sClient(contextName string, clientConfig clientcmd.ClientConfig, helmNamespace, helmDriver, sqlConnection string) (*k8s.Client, error) {
+	getter := &restClientGetter{clientConfig: clientConfig}
+
+	config, err := clientConfig.ClientConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	rawConfig, err := clientConfig.RawConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	if contextName == "" {
+		contextName = rawConfig.CurrentContext
+	}
+	// k8s.Client keeps the context name private: mirror the current context
+	// under the empty name so that ClusterName() keeps working.
+	if c, ok := rawConfig.Contexts[contextName]; ok {
+		rawConfig.Contexts[""] = c
+	}
+
+	ciliumClientset, err := ciliumClientset.NewForConfig(config)
+	if err != nil {
+		return nil, err
+	}
+
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, err
+	}
+
+	extensionClientset, err := apiextensionsclientset.NewForConfig(config)
+	if err != nil {
+		return nil, err
+	}
+
+	dynamicClientset, err := dynamic.NewForConfig(config)
+	if err != nil {
+		return nil, err
+	}
+
+	actionConfig, err := newHelmActionConfig(getter, helmNamespace, helmDriver, sqlConnection)
+	if err != nil {
+		return nil, err
+	}
+
+	return &k8s.Client{
+		CiliumClientset:    ciliumClientset,
+		Clientset:          clientset,
+		ExtensionClientset: extensionClientset,
+		Config:             config,
+		DynamicClientset:   dynamicClientset,
+		RawConfig:          rawConfig,
+		RESTClientGetter:   getter,
+		HelmActionConfig:   actionConfig,
+	}, nil
+}
+
+// newHelmActionConfig initializes a Helm action configuration which stores the
+// releases with the given driver (secret, configmap, memory or sql).
+func newHelmActionConfig(getter genericclioptions.RESTClientGetter, namespace, helmDriver, sqlConnection string) (*action.Configuration, error) {
+	actionConfig := action.Configuration{}
+	logger := func(format string, v ...interface{}) {}
+	if helmDriver != "sql" {
+		if err := actionConfig.Init(getter, namespace, helmDriver, logger); err != nil {
+			return nil, err
+		}
+		return &actionConfig, nil
+	}
+
+	// Helm reads the SQL connection string from the environment: give it
+	// explicitly instead.
+	if err := actionConfig.Init(getter, namespace, "memory", logger); err != nil {
+		return nil, err
+	}
+	d, err := driver.NewSQL(sqlConnection, logger, namespace)
+	if err != nil {
+		return nil, fmt.Errorf("unable to instantiate SQL driver: %w", err)
+	}
+	actionConfig.Releases = storage.Init(d)
+	return &actionConfig, nil
+}
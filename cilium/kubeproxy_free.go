@@ -5,11 +5,14 @@ package provider
 
 import (
 	"context"
+	"errors"
 	"fmt"
 
+	"github.com/cilium/cilium/cilium-cli/defaults"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	ktypes "k8s.io/apimachinery/pkg/types"
 
+	"github.com/hashicorp/terraform-plugin-framework-timeouts/resource/timeouts"
 	"github.com/hashicorp/terraform-plugin-framework/path"
 	"github.com/hashicorp/terraform-plugin-framework/resource"
 	"github.com/hashicorp/terraform-plugin-framework/resource/schema"
@@ -35,9 +38,10 @@ type CiliumKubeProxyDisabledResource struct {
 
 // CiliumInstallResourceModel describes the resource data model.
 type CiliumKubeProxyDisabledResourceModel struct {
-	Name      types.String `tfsdk:"name"`
-	Namespace types.String `tfsdk:"namespace"`
-	Id        types.String `tfsdk:"id"`
+	Name      types.String   `tfsdk:"name"`
+	Namespace types.String   `tfsdk:"namespace"`
+	Id        types.String   `tfsdk:"id"`
+	Timeouts  timeouts.Value `tfsdk:"timeouts"`
 }
 
 func (r *CiliumKubeProxyDisabledResource) Metadata(ctx context.Context, req resource.MetadataRequest, resp *resource.MetadataResponse) {
@@ -70,6 +74,14 @@ func (r *CiliumKubeProxyDisabledResource) Schema(ctx context.Context, req resour
 				},
 			},
 		},
+		Blocks: map[string]schema.Block{
+			"timeouts": timeouts.Block(ctx, timeouts.Opts{
+				Create: true,
+				Read:   true,
+				Update: true,
+				Delete: true,
+			}),
+		},
 	}
 }
 
@@ -100,7 +112,11 @@ func (r *CiliumKubeProxyDisabledResource) Create(ctx context.Context, req resour
 		resp.Diagnostics.AddError("Client Error", "Unable to connect to kubernetes")
 		return
 	}
-	k8sClient := c.client
+	k8sClient, err := c.K8sClient()
+	if err != nil {
+		resp.Diagnostics.AddError("Client Error", fmt.Sprintf("Unable to connect to kubernetes: %s", err))
+		return
+	}
 
 	// Read Terraform plan data into the model
 	resp.Diagnostics.Append(req.Plan.Get(ctx, &data)...)
@@ -108,6 +124,15 @@ func (r *CiliumKubeProxyDisabledResource) Create(ctx context.Context, req resour
 	if resp.Diagnostics.HasError() {
 		return
 	}
+
+	createTimeout, diags := data.Timeouts.Create(ctx, defaults.StatusWaitDuration)
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+	ctx, cancel := context.WithTimeout(ctx, createTimeout)
+	defer cancel()
+
 	name := data.Name.ValueString()
 	namespace := data.Namespace.ValueString()
 	nodeSelectorKey := "non-existing"
@@ -115,11 +140,11 @@ func (r *CiliumKubeProxyDisabledResource) Create(ctx context.Context, req resour
 	patch := []byte(fmt.Sprintf(`{"spec":{"template":{"spec":{"nodeSelector":{"%s":"%s"}}}}}`, nodeSelectorKey, nodeSelectorValue))
 
 	if err := c.CheckDaemonsetAvailability(ctx, namespace, name); err != nil {
-		resp.Diagnostics.AddError("Client Error", fmt.Sprintf("%s", err))
+		resp.Diagnostics.AddError("Client Error", interrupted(ctx, err).Error())
 	}
 
 	if _, err := k8sClient.PatchDaemonSet(ctx, namespace, name, ktypes.StrategicMergePatchType, patch, metav1.PatchOptions{FieldManager: "Terraform"}); err != nil {
-		resp.Diagnostics.AddError("Client Error", fmt.Sprintf("%s", err))
+		resp.Diagnostics.AddError("Client Error", interrupted(ctx, err).Error())
 		return
 	}
 
@@ -142,6 +167,15 @@ func (r *CiliumKubeProxyDisabledResource) Read(ctx context.Context, req resource
 		resp.Diagnostics.AddError("Client Error", "Unable to connect to kubernetes")
 		return
 	}
+	_, err := c.K8sClient()
+	if errors.Is(err, errProviderConfigUnknown) {
+		// Keep the prior state until the provider configuration is known.
+		return
+	}
+	if err != nil {
+		resp.Diagnostics.AddError("Client Error", fmt.Sprintf("Unable to connect to kubernetes: %s", err))
+		return
+	}
 
 	// Read Terraform prior state data into the model
 	resp.Diagnostics.Append(req.State.Get(ctx, &data)...)
@@ -149,12 +183,28 @@ func (r *CiliumKubeProxyDisabledResource) Read(ctx context.Context, req resource
 	if resp.Diagnostics.HasError() {
 		return
 	}
+
+	readTimeout, diags := data.Timeouts.Read(ctx, defaults.StatusWaitDuration)
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+	ctx, cancel := context.WithTimeout(ctx, readTimeout)
+	defer cancel()
+
 	name := data.Name.ValueString()
 	namespace := data.Namespace.ValueString()
-	if err := c.CheckDaemonsetStatus(ctx, namespace, name); err != nil {
+	err = c.CheckDaemonsetStatus(ctx, namespace, name)
+	// kube-proxy runs again, or its DaemonSet was deleted: the resource has
+	// to be created again.
+	if errors.Is(err, errDaemonsetRunning) || isNotFound(err) {
 		resp.State.RemoveResource(ctx)
 		return
 	}
+	if err != nil {
+		resp.Diagnostics.AddError("Client Error", fmt.Sprintf("Unable to read DaemonSet: %s", interrupted(ctx, err)))
+		return
+	}
 
 	// Save updated data into Terraform state
 	resp.Diagnostics.Append(resp.State.Set(ctx, &data)...)
@@ -167,7 +217,11 @@ func (r *CiliumKubeProxyDisabledResource) Update(ctx context.Context, req resour
 		resp.Diagnostics.AddError("Client Error", "Unable to connect to kubernetes")
 		return
 	}
-	k8sClient := c.client
+	k8sClient, err := c.K8sClient()
+	if err != nil {
+		resp.Diagnostics.AddError("Client Error", fmt.Sprintf("Unable to connect to kubernetes: %s", err))
+		return
+	}
 
 	// Read Terraform plan data into the model
 	resp.Diagnostics.Append(req.Plan.Get(ctx, &data)...)
@@ -175,6 +229,15 @@ func (r *CiliumKubeProxyDisabledResource) Update(ctx context.Context, req resour
 	if resp.Diagnostics.HasError() {
 		return
 	}
+
+	updateTimeout, diags := data.Timeouts.Update(ctx, defaults.StatusWaitDuration)
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+	ctx, cancel := context.WithTimeout(ctx, updateTimeout)
+	defer cancel()
+
 	name := data.Name.ValueString()
 	namespace := data.Namespace.ValueString()
 	nodeSelectorKey := "non-existing"
@@ -182,11 +245,11 @@ func (r *CiliumKubeProxyDisabledResource) Update(ctx context.Context, req resour
 	patch := []byte(fmt.Sprintf(`{"spec":{"template":{"spec":{"nodeSelector":{"%s":"%s"}}}}}`, nodeSelectorKey, nodeSelectorValue))
 
 	if err := c.CheckDaemonsetAvailability(ctx, namespace, name); err != nil {
-		resp.Diagnostics.AddError("Client Error", fmt.Sprintf("%s", err))
+		resp.Diagnostics.AddError("Client Error", interrupted(ctx, err).Error())
 	}
 
 	if _, err := k8sClient.PatchDaemonSet(ctx, namespace, name, ktypes.StrategicMergePatchType, patch, metav1.PatchOptions{FieldManager: "Terraform"}); err != nil {
-		resp.Diagnostics.AddError("Client Error", fmt.Sprintf("%s", err))
+		resp.Diagnostics.AddError("Client Error", interrupted(ctx, err).Error())
 		return
 	}
 
@@ -201,7 +264,11 @@ func (r *CiliumKubeProxyDisabledResource) Delete(ctx context.Context, req resour
 		resp.Diagnostics.AddError("Client Error", "Unable to connect to kubernetes")
 		return
 	}
-	k8sClient := c.client
+	k8sClient, err := c.K8sClient()
+	if err != nil {
+		resp.Diagnostics.AddError("Client Error", fmt.Sprintf("Unable to connect to kubernetes: %s", err))
+		return
+	}
 
 	// Read Terraform prior state data into the model
 	resp.Diagnostics.Append(req.State.Get(ctx, &data)...)
@@ -210,17 +277,25 @@ func (r *CiliumKubeProxyDisabledResource) Delete(ctx context.Context, req resour
 		return
 	}
 
+	deleteTimeout, diags := data.Timeouts.Delete(ctx, defaults.StatusWaitDuration)
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+	ctx, cancel := context.WithTimeout(ctx, deleteTimeout)
+	defer cancel()
+
 	name := data.Name.ValueString()
 	namespace := data.Namespace.ValueString()
 	nodeSelectorKey := "non-existing"
 	patch := []byte(fmt.Sprintf(`[{"op":"remove","path":"/spec/template/spec/nodeSelector/%s"}]`, nodeSelectorKey))
 
 	if err := c.CheckDaemonsetAvailability(ctx, namespace, name); err != nil {
-		resp.Diagnostics.AddError("Client Error", fmt.Sprintf("%s", err))
+		resp.Diagnostics.AddError("Client Error", interrupted(ctx, err).Error())
 	}
 
 	if _, err := k8sClient.PatchDaemonSet(ctx, namespace, name, ktypes.JSONPatchType, patch, metav1.PatchOptions{FieldManager: "Terraform"}); err != nil {
-		resp.Diagnostics.AddError("Client Error", fmt.Sprintf("%s", err))
+		resp.Diagnostics.AddError("Client Error", interrupted(ctx, err).Error())
 		return
 	}
 }
@@ -4,8 +4,15 @@
 package provider
 
 import (
+	"context"
+	"net/http"
 	"testing"
 
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	fwresource "github.com/hashicorp/terraform-plugin-framework/resource"
+	"github.com/hashicorp/terraform-plugin-go/tftypes"
 	"github.com/hashicorp/terraform-plugin-testing/helper/resource"
 )
 
@@ -52,3 +59,60 @@ resource "cilium_kubeproxy_free" "test" {
 }
 `
 }
+
+func TestCiliumKubeProxyDisabledResourceRead(t *testing.T) {
+	daemonset := "/apis/apps/v1/namespaces/kube-system/daemonsets/kube-proxy"
+	for name, tc := range map[string]struct {
+		routes  map[string]http.HandlerFunc
+		removed bool
+		errors  int
+	}{
+		"disabled": {
+			routes: map[string]http.HandlerFunc{
+				daemonset: testObject(appsv1.DaemonSet{
+					TypeMeta:   metav1.TypeMeta{APIVersion: "apps/v1", Kind: "DaemonSet"},
+					ObjectMeta: metav1.ObjectMeta{Name: "kube-proxy", Namespace: "kube-system"},
+				}),
+			},
+		},
+		"running again": {
+			routes: map[string]http.HandlerFunc{
+				daemonset: testObject(appsv1.DaemonSet{
+					TypeMeta:   metav1.TypeMeta{APIVersion: "apps/v1", Kind: "DaemonSet"},
+					ObjectMeta: metav1.ObjectMeta{Name: "kube-proxy", Namespace: "kube-system"},
+					Status:     appsv1.DaemonSetStatus{NumberReady: 3},
+				}),
+			},
+			removed: true,
+		},
+		"deleted": {
+			removed: true,
+		},
+		"transient error": {
+			routes: map[string]http.HandlerFunc{
+				daemonset: testStatusError(http.StatusServiceUnavailable, "etcdserver: request timed out"),
+			},
+			errors: 1,
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			srv := testFakeCluster(t, "kind-test", tc.routes)
+			r := NewCiliumKubeProxyDisabledResource()
+			testResourceClient(t, r, srv, nil)
+
+			state := testResourceState(t, r, map[string]tftypes.Value{
+				"name":      tftypes.NewValue(tftypes.String, "kube-proxy"),
+				"namespace": tftypes.NewValue(tftypes.String, "kube-system"),
+			})
+			resp := &fwresource.ReadResponse{State: state}
+			r.Read(context.Background(), fwresource.ReadRequest{State: state}, resp)
+
+			if got := resp.Diagnostics.ErrorsCount(); got != tc.errors {
+				t.Errorf("got %d errors: %v", got, resp.Diagnostics)
+			}
+			if removed := resp.State.Raw.IsNull(); removed != tc.removed {
+				t.Errorf("removed: got %t, expected %t", removed, tc.removed)
+			}
+		})
+	}
+}
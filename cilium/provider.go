@@ -5,21 +5,27 @@ package provider
 
 import (
 	"context"
-	"encoding/base64"
-	"fmt"
 	"os"
 
 	"github.com/cilium/cilium/cilium-cli/k8s"
+	"k8s.io/client-go/tools/clientcmd"
 
+	"github.com/hashicorp/terraform-plugin-framework-validators/stringvalidator"
 	"github.com/hashicorp/terraform-plugin-framework/datasource"
+	"github.com/hashicorp/terraform-plugin-framework/ephemeral"
+	"github.com/hashicorp/terraform-plugin-framework/function"
+	"github.com/hashicorp/terraform-plugin-framework/path"
 	"github.com/hashicorp/terraform-plugin-framework/provider"
 	"github.com/hashicorp/terraform-plugin-framework/provider/schema"
 	"github.com/hashicorp/terraform-plugin-framework/resource"
+	"github.com/hashicorp/terraform-plugin-framework/schema/validator"
 	"github.com/hashicorp/terraform-plugin-framework/types"
 )
 
 // Ensure CiliumProvider satisfies various provider interfaces.
 var _ provider.Provider = &CiliumProvider{}
+var _ provider.ProviderWithFunctions = &CiliumProvider{}
+var _ provider.ProviderWithEphemeralResources = &CiliumProvider{}
 
 // CiliumProvider defines the provider implementation.
 type CiliumProvider struct {
@@ -31,11 +37,28 @@ type CiliumProvider struct {
 
 // CiliumProviderModel describes the provider data model.
 type CiliumProviderModel struct {
-	Context       types.String `tfsdk:"context"`
-	ConfigPath    types.String `tfsdk:"config_path"`
-	ConfigContent types.String `tfsdk:"config_content"`
-	Namespace     types.String `tfsdk:"namespace"`
-	HelmRelease   types.String `tfsdk:"helm_release"`
+	Context              types.String             `tfsdk:"context"`
+	ConfigPath           types.String             `tfsdk:"config_path"`
+	ConfigContent        types.String             `tfsdk:"config_content"`
+	Host                 types.String             `tfsdk:"host"`
+	ClusterCACertificate types.String             `tfsdk:"cluster_ca_certificate"`
+	ClientCertificate    types.String             `tfsdk:"client_certificate"`
+	ClientKey            types.String             `tfsdk:"client_key"`
+	Token                types.String             `tfsdk:"token"`
+	Insecure             types.Bool               `tfsdk:"insecure"`
+	TLSServerName        types.String             `tfsdk:"tls_server_name"`
+	ProxyURL             types.String             `tfsdk:"proxy_url"`
+	Exec                 *CiliumProviderExecModel `tfsdk:"exec"`
+	ImpersonateUser      types.String             `tfsdk:"impersonate_user"`
+	ImpersonateUID       types.String             `tfsdk:"impersonate_uid"`
+	ImpersonateGroups    types.List               `tfsdk:"impersonate_groups"`
+	ImpersonateExtra     types.Map                `tfsdk:"impersonate_extra"`
+	Namespace            types.String             `tfsdk:"namespace"`
+	HelmRelease          types.String             `tfsdk:"helm_release"`
+	HelmDriver           types.String             `tfsdk:"helm_driver"`
+	HelmNamespace        types.String             `tfsdk:"helm_namespace"`
+	HelmSQLConnection    types.String             `tfsdk:"helm_sql_connection_string"`
+	StoreCAKey           types.Bool               `tfsdk:"store_ca_key"`
 }
 
 func (p *CiliumProvider) Metadata(ctx context.Context, req provider.MetadataRequest, resp *provider.MetadataResponse) {
@@ -55,7 +78,59 @@ func (p *CiliumProvider) Schema(ctx context.Context, req provider.SchemaRequest,
 				Optional:            true,
 			},
 			"config_content": schema.StringAttribute{
-				MarkdownDescription: ConcatDefault("The content of kube config file", ""),
+				MarkdownDescription: ConcatDefault("The content of kube config file, in raw yaml or in base 64. Overrides config_path", ""),
+				Optional:            true,
+			},
+			"host": schema.StringAttribute{
+				MarkdownDescription: "The hostname (in form of URI) of the Kubernetes API server. Overrides the kube config file",
+				Optional:            true,
+			},
+			"cluster_ca_certificate": schema.StringAttribute{
+				MarkdownDescription: "PEM-encoded root certificates bundle for TLS authentication",
+				Optional:            true,
+			},
+			"client_certificate": schema.StringAttribute{
+				MarkdownDescription: "PEM-encoded client certificate for TLS authentication",
+				Optional:            true,
+			},
+			"client_key": schema.StringAttribute{
+				MarkdownDescription: "PEM-encoded client certificate key for TLS authentication",
+				Optional:            true,
+				Sensitive:           true,
+			},
+			"token": schema.StringAttribute{
+				MarkdownDescription: "Token to authenticate a service account",
+				Optional:            true,
+				Sensitive:           true,
+			},
+			"insecure": schema.BoolAttribute{
+				MarkdownDescription: ConcatDefault("Whether server should be accessed without verifying the TLS certificate", "false"),
+				Optional:            true,
+			},
+			"tls_server_name": schema.StringAttribute{
+				MarkdownDescription: "Server name passed to the server for SNI and is used in the client to check server certificates against",
+				Optional:            true,
+			},
+			"proxy_url": schema.StringAttribute{
+				MarkdownDescription: "URL to the proxy to be used for all API requests",
+				Optional:            true,
+			},
+			"impersonate_user": schema.StringAttribute{
+				MarkdownDescription: "Username to impersonate for every Kubernetes operation",
+				Optional:            true,
+			},
+			"impersonate_uid": schema.StringAttribute{
+				MarkdownDescription: "UID to impersonate for every Kubernetes operation",
+				Optional:            true,
+			},
+			"impersonate_groups": schema.ListAttribute{
+				ElementType:         types.StringType,
+				MarkdownDescription: "Groups to impersonate for every Kubernetes operation",
+				Optional:            true,
+			},
+			"impersonate_extra": schema.MapAttribute{
+				ElementType:         types.ListType{ElemType: types.StringType},
+				MarkdownDescription: "Extra user information to impersonate for every Kubernetes operation",
 				Optional:            true,
 			},
 			"namespace": schema.StringAttribute{
@@ -66,6 +141,51 @@ func (p *CiliumProvider) Schema(ctx context.Context, req provider.SchemaRequest,
 				MarkdownDescription: ConcatDefault("Helm Release to install cilium", "cilium"),
 				Optional:            true,
 			},
+			"helm_driver": schema.StringAttribute{
+				MarkdownDescription: ConcatDefault("Helm storage driver of the release { secret | configmap | memory | sql }", "secret"),
+				Optional:            true,
+				Validators: []validator.String{
+					stringvalidator.OneOf("secret", "configmap", "memory", "sql"),
+				},
+			},
+			"helm_namespace": schema.StringAttribute{
+				MarkdownDescription: ConcatDefault("Namespace where helm stores the release", "namespace"),
+				Optional:            true,
+			},
+			"helm_sql_connection_string": schema.StringAttribute{
+				MarkdownDescription: ConcatDefault("Connection string of the sql helm driver", "`HELM_DRIVER_SQL_CONNECTION_STRING` environment variable"),
+				Optional:            true,
+				Sensitive:           true,
+			},
+			"store_ca_key": schema.BoolAttribute{
+				MarkdownDescription: ConcatDefault("Store the private key of the Cilium CA in the `ca` attribute of the `cilium` resource. Set to false to keep it out of the state and read it with the `cilium_ca` ephemeral resource", "true"),
+				Optional:            true,
+			},
+		},
+		Blocks: map[string]schema.Block{
+			"exec": schema.SingleNestedBlock{
+				MarkdownDescription: "Exec credential plugin used to get a token (e.g. `aws eks get-token`)",
+				Attributes: map[string]schema.Attribute{
+					"api_version": schema.StringAttribute{
+						MarkdownDescription: "API version of the exec credential plugin (e.g. `client.authentication.k8s.io/v1beta1`)",
+						Optional:            true,
+					},
+					"command": schema.StringAttribute{
+						MarkdownDescription: "Command to execute",
+						Optional:            true,
+					},
+					"args": schema.ListAttribute{
+						ElementType:         types.StringType,
+						MarkdownDescription: "Arguments to pass when executing the plugin",
+						Optional:            true,
+					},
+					"env": schema.MapAttribute{
+						ElementType:         types.StringType,
+						MarkdownDescription: "Environment variables to set when executing the plugin",
+						Optional:            true,
+					},
+				},
+			},
 		},
 	}
 }
@@ -80,10 +200,10 @@ func (p *CiliumProvider) Configure(ctx context.Context, req provider.ConfigureRe
 	}
 
 	namespace := data.Namespace.ValueString()
-	config_path := data.ConfigPath.ValueString()
 	context := data.Context.ValueString()
 	helm_release := data.HelmRelease.ValueString()
-	config_content := data.ConfigContent.ValueString()
+	impersonate_as := data.ImpersonateUser.ValueString()
+	impersonate_groups := ValueList(ctx, data.ImpersonateGroups)
 
 	if helm_release == "" {
 		helm_release = "cilium"
@@ -91,42 +211,71 @@ func (p *CiliumProvider) Configure(ctx context.Context, req provider.ConfigureRe
 	if namespace == "" {
 		namespace = "kube-system"
 	}
-	if config_content != "" {
-		config_content_str, err := base64.StdEncoding.DecodeString(config_content)
-		if err != nil {
-			panic(err)
-		}
-		f, err := os.CreateTemp("", "kubeconfig")
-		if err != nil {
-			panic(err)
-		}
-		if _, err := f.Write(config_content_str); err != nil {
-			panic(err)
+	helm_driver := data.HelmDriver.ValueString()
+	if helm_driver == "" {
+		helm_driver = "secret"
+	}
+	// An empty helm_namespace is the namespace of each release.
+	helm_namespace := data.HelmNamespace.ValueString()
+	helm_sql_connection := data.HelmSQLConnection.ValueString()
+	if helm_sql_connection == "" {
+		helm_sql_connection = os.Getenv("HELM_DRIVER_SQL_CONNECTION_STRING")
+	}
+
+	store_ca_key := true
+	if !data.StoreCAKey.IsNull() {
+		store_ca_key = data.StoreCAKey.ValueBool()
+	}
+
+	client := &CiliumClient{namespace: namespace, helm_release: helm_release, helm_namespace: helm_namespace, impersonate_as: impersonate_as, impersonate_groups: impersonate_groups, store_ca_key: store_ca_key}
+
+	// The provider configuration is unknown during the plan when the cluster
+	// is created in the same apply.
+	if !req.Config.Raw.IsFullyKnown() {
+		if req.ClientCapabilities.DeferralAllowed {
+			resp.Deferred = &provider.Deferred{Reason: provider.DeferredReasonProviderConfigUnknown}
+			return
 		}
-		if err := f.Close(); err != nil {
-			panic(err)
+		client.connect = func(helmNamespace string) (*k8s.Client, error) {
+			return nil, errProviderConfigUnknown
 		}
-		config_path = f.Name()
-	}
-	if config_path != "" {
-		os.Setenv("KUBECONFIG", config_path)
+		resp.DataSourceData = client
+		resp.ResourceData = client
+		resp.EphemeralResourceData = client
+		return
 	}
 
-	impersonate_as := ""
-	impersonate_groups := []string{}
+	if data.Exec != nil && (data.Exec.Command.ValueString() == "" || data.Exec.APIVersion.ValueString() == "") {
+		resp.Diagnostics.AddAttributeError(path.Root("exec"), "Invalid exec configuration", "Both `api_version` and `command` must be set in the exec block.")
+		return
+	}
 
-	client, err := k8s.NewClient(context, config_path, namespace, impersonate_as, impersonate_groups)
+	clientConfig, err := data.clientConfig(ctx)
 	if err != nil {
-		fmt.Printf("unable to create Kubernetes client: %v\n", err)
+		resp.Diagnostics.AddAttributeError(path.Root("config_content"), "Invalid kube config content", err.Error())
 		return
 	}
 
-	// Configuration values are now available.
-	// if data.Endpoint.IsNull() { /* ... */ }
-
-	// Example client configuration for data sources and resources
-	resp.DataSourceData = &CiliumClient{client: client, namespace: namespace, helm_release: helm_release}
-	resp.ResourceData = &CiliumClient{client: client, namespace: namespace, helm_release: helm_release}
+	// The Kubernetes client is only created when a resource or a data source
+	// needs it.
+	client.connect = func(helmNamespace string) (*k8s.Client, error) {
+		return newK8sClient(context, clientConfig, helmNamespace, helm_driver, helm_sql_connection)
+	}
+	// The remote clusters of Cluster Mesh are other contexts of the same
+	// kubeconfig.
+	client.connectContext = func(contextName, helmNamespace string) (*k8s.Client, error) {
+		rawConfig, err := clientConfig.RawConfig()
+		if err != nil {
+			return nil, err
+		}
+		overrides := &clientcmd.ConfigOverrides{CurrentContext: contextName}
+		overrides.AuthInfo.Impersonate = impersonate_as
+		overrides.AuthInfo.ImpersonateGroups = impersonate_groups
+		return newK8sClient(contextName, clientcmd.NewNonInteractiveClientConfig(rawConfig, contextName, overrides, nil), helmNamespace, helm_driver, helm_sql_connection)
+	}
+	resp.DataSourceData = client
+	resp.ResourceData = client
+	resp.EphemeralResourceData = client
 }
 
 func (p *CiliumProvider) Resources(ctx context.Context) []func() resource.Resource {
@@ -143,6 +292,21 @@ func (p *CiliumProvider) Resources(ctx context.Context) []func() resource.Resour
 func (p *CiliumProvider) DataSources(ctx context.Context) []func() datasource.DataSource {
 	return []func() datasource.DataSource{
 		NewCiliumHelmValuesDataSource,
+		NewCiliumHelmReleaseHistoryDataSource,
+	}
+}
+
+func (p *CiliumProvider) EphemeralResources(ctx context.Context) []func() ephemeral.EphemeralResource {
+	return []func() ephemeral.EphemeralResource{
+		NewCiliumCAEphemeralResource,
+	}
+}
+
+func (p *CiliumProvider) Functions(ctx context.Context) []func() function.Function {
+	return []func() function.Function{
+		NewMergeValuesFunction,
+		NewSetToValuesFunction,
+		NewValuesGetFunction,
 	}
 }
 
@@ -4,10 +4,43 @@
 package provider
 
 import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/cilium/cilium/cilium-cli/clustermesh"
+	"github.com/cilium/cilium/cilium-cli/k8s"
+
+	"helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/release"
+	"helm.sh/helm/v3/pkg/storage/driver"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/version"
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+
+	"github.com/hashicorp/terraform-plugin-framework/provider"
 	"github.com/hashicorp/terraform-plugin-framework/providerserver"
+	"github.com/hashicorp/terraform-plugin-framework/resource"
+	"github.com/hashicorp/terraform-plugin-framework/tfsdk"
 	"github.com/hashicorp/terraform-plugin-go/tfprotov6"
+	"github.com/hashicorp/terraform-plugin-go/tftypes"
 )
 
 // testAccProtoV6ProviderFactories are used to instantiate a provider during
@@ -23,3 +56,504 @@ func testAccPreCheck(t *testing.T) {
 	// about the appropriate environment variables being set are common to see in a pre-check
 	// function.
 }
+
+// testProviderConfigure runs the provider Configure method with the given
+// attributes, the other ones being null.
+func testProviderConfigure(t *testing.T, attributes map[string]tftypes.Value, capabilities provider.ConfigureProviderClientCapabilities) *provider.ConfigureResponse {
+	t.Helper()
+	ctx := context.Background()
+	p := New("test")()
+
+	schemaResp := &provider.SchemaResponse{}
+	p.Schema(ctx, provider.SchemaRequest{}, schemaResp)
+	objectType := schemaResp.Schema.Type().TerraformType(ctx).(tftypes.Object)
+
+	values := map[string]tftypes.Value{}
+	for name, attributeType := range objectType.AttributeTypes {
+		if v, ok := attributes[name]; ok {
+			values[name] = v
+		} else {
+			values[name] = tftypes.NewValue(attributeType, nil)
+		}
+	}
+
+	req := provider.ConfigureRequest{
+		Config: tfsdk.Config{
+			Schema: schemaResp.Schema,
+			Raw:    tftypes.NewValue(objectType, values),
+		},
+		ClientCapabilities: capabilities,
+	}
+	resp := &provider.ConfigureResponse{}
+	p.Configure(ctx, req, resp)
+	return resp
+}
+
+// testResourceState builds the state of a resource with the given
+// attributes, the other ones being null.
+func testResourceState(t *testing.T, r resource.Resource, attributes map[string]tftypes.Value) tfsdk.State {
+	t.Helper()
+	ctx := context.Background()
+
+	schemaResp := &resource.SchemaResponse{}
+	r.Schema(ctx, resource.SchemaRequest{}, schemaResp)
+	objectType := schemaResp.Schema.Type().TerraformType(ctx).(tftypes.Object)
+
+	values := map[string]tftypes.Value{}
+	for name, attributeType := range objectType.AttributeTypes {
+		if v, ok := attributes[name]; ok {
+			values[name] = v
+		} else {
+			values[name] = tftypes.NewValue(attributeType, nil)
+		}
+	}
+	return tfsdk.State{
+		Schema: schemaResp.Schema,
+		Raw:    tftypes.NewValue(objectType, values),
+	}
+}
+
+// testResourcePlan builds the plan of a resource with the given attributes,
+// the other ones being null.
+func testResourcePlan(t *testing.T, r resource.Resource, attributes map[string]tftypes.Value) tfsdk.Plan {
+	t.Helper()
+	state := testResourceState(t, r, attributes)
+	return tfsdk.Plan{Schema: state.Schema, Raw: state.Raw}
+}
+
+// testTimeouts is a timeouts block with the given read timeout.
+func testTimeouts(read string) tftypes.Value {
+	attributeTypes := map[string]tftypes.Type{
+		"create": tftypes.String,
+		"read":   tftypes.String,
+		"update": tftypes.String,
+		"delete": tftypes.String,
+	}
+	return tftypes.NewValue(tftypes.Object{AttributeTypes: attributeTypes}, map[string]tftypes.Value{
+		"create": tftypes.NewValue(tftypes.String, nil),
+		"read":   tftypes.NewValue(tftypes.String, read),
+		"update": tftypes.NewValue(tftypes.String, nil),
+		"delete": tftypes.NewValue(tftypes.String, nil),
+	})
+}
+
+// testFakeCluster starts a minimal Kubernetes API server which only knows its
+// version, the kube-system namespace, labelled with the name of the cluster,
+// and the cilium-test namespace, which never finishes terminating. routes
+// serve additional paths.
+func testFakeCluster(t *testing.T, name string, routes map[string]http.HandlerFunc) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if route, ok := routes[r.URL.Path]; ok {
+			route(w, r)
+			return
+		}
+		switch r.URL.Path {
+		case "/version":
+			json.NewEncoder(w).Encode(version.Info{Major: "1", Minor: "33", GitVersion: "v1.33.1"})
+		case "/api/v1/namespaces/kube-system":
+			json.NewEncoder(w).Encode(corev1.Namespace{
+				TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "Namespace"},
+				ObjectMeta: metav1.ObjectMeta{Name: "kube-system", Labels: map[string]string{"cluster": name}},
+			})
+		case "/api/v1/namespaces/cilium-test":
+			json.NewEncoder(w).Encode(corev1.Namespace{
+				TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "Namespace"},
+				ObjectMeta: metav1.ObjectMeta{Name: "cilium-test"},
+				Status:     corev1.NamespaceStatus{Phase: corev1.NamespaceTerminating},
+			})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// testStatusError answers with a Kubernetes API error.
+func testStatusError(code int32, message string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(int(code))
+		json.NewEncoder(w).Encode(metav1.Status{
+			TypeMeta: metav1.TypeMeta{APIVersion: "v1", Kind: "Status"},
+			Status:   metav1.StatusFailure,
+			Message:  message,
+			Code:     code,
+		})
+	}
+}
+
+// testObject answers with a Kubernetes object.
+func testObject(object interface{}) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(object)
+	}
+}
+
+// testResourceClient configures a resource with a provider connected to srv,
+// the given provider attributes being added to the connection.
+func testResourceClient(t *testing.T, r resource.Resource, srv *httptest.Server, attributes map[string]tftypes.Value) *CiliumClient {
+	t.Helper()
+	providerAttributes := map[string]tftypes.Value{
+		"config_content": tftypes.NewValue(tftypes.String, testKubeConfig(srv.URL, "kind-test")),
+	}
+	for name, v := range attributes {
+		providerAttributes[name] = v
+	}
+	providerResp := testProviderConfigure(t, providerAttributes, provider.ConfigureProviderClientCapabilities{})
+	if providerResp.Diagnostics.HasError() {
+		t.Fatalf("unexpected diagnostics: %v", providerResp.Diagnostics)
+	}
+	r.(resource.ResourceWithConfigure).Configure(context.Background(), resource.ConfigureRequest{ProviderData: providerResp.ResourceData}, &resource.ConfigureResponse{})
+	c := providerResp.ResourceData.(*CiliumClient)
+	if _, err := c.K8sClient(); err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+// testCreateRelease stores a Cilium release with the helm driver of c.
+func testCreateRelease(t *testing.T, c *CiliumClient, config map[string]interface{}) {
+	t.Helper()
+	rel := &release.Release{
+		Name:      c.helm_release,
+		Namespace: c.namespace,
+		Version:   1,
+		Info:      &release.Info{Status: release.StatusDeployed},
+		Chart:     &chart.Chart{Metadata: &chart.Metadata{Name: "cilium", Version: "1.17.3", AppVersion: "1.17.3"}},
+		Config:    config,
+	}
+	if err := c.client.HelmActionConfig.Releases.Create(rel); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// testCACertificate returns a self-signed CA certificate in PEM.
+func testCACertificate(t *testing.T) []byte {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Cilium CA"},
+		NotBefore:             time.Now(),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		SubjectKeyId:          []byte{1, 2, 3, 4},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+}
+
+// testClusterMeshRoutes answers with the Cilium configuration and the
+// clustermesh-apiserver of a cluster: its service gets the cluster IP ip.
+func testClusterMeshRoutes(name, id, ip string, ca []byte) map[string]http.HandlerFunc {
+	return map[string]http.HandlerFunc{
+		"/api/v1/namespaces/kube-system/configmaps/cilium-config": testObject(corev1.ConfigMap{
+			TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "ConfigMap"},
+			ObjectMeta: metav1.ObjectMeta{Name: "cilium-config", Namespace: "kube-system"},
+			Data:       map[string]string{"cluster-name": name, "cluster-id": id},
+		}),
+		"/api/v1/namespaces/kube-system/services/clustermesh-apiserver": testObject(corev1.Service{
+			TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "Service"},
+			ObjectMeta: metav1.ObjectMeta{Name: "clustermesh-apiserver", Namespace: "kube-system"},
+			Spec: corev1.ServiceSpec{
+				Type:      corev1.ServiceTypeClusterIP,
+				ClusterIP: ip,
+				Ports:     []corev1.ServicePort{{Port: 2379}},
+			},
+		}),
+		"/api/v1/namespaces/kube-system/secrets/clustermesh-apiserver-remote-cert": testObject(corev1.Secret{
+			TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "Secret"},
+			ObjectMeta: metav1.ObjectMeta{Name: "clustermesh-apiserver-remote-cert", Namespace: "kube-system"},
+			Data:       map[string][]byte{"tls.crt": []byte("cert-" + name), "tls.key": []byte("key-" + name)},
+		}),
+		"/api/v1/namespaces/kube-system/secrets/cilium-ca": testObject(corev1.Secret{
+			TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "Secret"},
+			ObjectMeta: metav1.ObjectMeta{Name: "cilium-ca", Namespace: "kube-system"},
+			Data:       map[string][]byte{"ca.crt": ca},
+		}),
+	}
+}
+
+// testClusterMeshUpgrade replaces the Helm upgrades of Cluster Mesh: the new
+// revision reuses the values of the last one.
+func testClusterMeshUpgrade(ctx context.Context, client *k8s.Client, params clustermesh.Parameters, values map[string]interface{}) error {
+	releases := client.HelmActionConfig.Releases
+	last, err := releases.Last(params.HelmReleaseName)
+	if err != nil {
+		return err
+	}
+	next := *last
+	next.Version = last.Version + 1
+	next.Config = mergeMaps(last.Config, values)
+	superseded := *last
+	superseded.Info = &release.Info{Status: release.StatusSuperseded}
+	if err := releases.Update(&superseded); err != nil {
+		return err
+	}
+	return releases.Create(&next)
+}
+
+// testKubeConfigContexts returns a kubeconfig with a context per cluster, by
+// name, the first one being the current context.
+func testKubeConfigContexts(t *testing.T, servers ...[2]string) string {
+	t.Helper()
+	var kubeconfig *clientcmdapi.Config
+	for _, server := range servers {
+		config, err := parseKubeConfig(testKubeConfig(server[1], server[0]))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if kubeconfig == nil {
+			kubeconfig = config
+			continue
+		}
+		kubeconfig.Clusters[server[0]] = config.Clusters[server[0]]
+		kubeconfig.AuthInfos[server[0]] = config.AuthInfos[server[0]]
+		kubeconfig.Contexts[server[0]] = config.Contexts[server[0]]
+	}
+	raw, err := clientcmd.Write(*kubeconfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(raw)
+}
+
+func testKubeConfig(server, name string) string {
+	return fmt.Sprintf(`apiVersion: v1
+kind: Config
+clusters:
+- name: %[2]s
+  cluster:
+    server: %[1]s
+contexts:
+- name: %[2]s
+  context:
+    cluster: %[2]s
+    user: %[2]s
+current-context: %[2]s
+users:
+- name: %[2]s
+  user:
+    token: token-%[2]s
+`, server, name)
+}
+
+func TestProviderConfigureAliases(t *testing.T) {
+	kubeconfigEnv, kubeconfigSet := os.LookupEnv("KUBECONFIG")
+	clusters := []string{"kind-one", "kind-two"}
+	servers := map[string]*httptest.Server{}
+	for _, name := range clusters {
+		servers[name] = testFakeCluster(t, name, nil)
+	}
+
+	clients := make([]*CiliumClient, len(clusters))
+	var wg sync.WaitGroup
+	for i, name := range clusters {
+		wg.Add(1)
+		go func(i int, name string) {
+			defer wg.Done()
+			content := testKubeConfig(servers[name].URL, name)
+			// One alias uses raw yaml, the other one base 64.
+			if i%2 == 1 {
+				content = base64.StdEncoding.EncodeToString([]byte(content))
+			}
+			resp := testProviderConfigure(t, map[string]tftypes.Value{
+				"config_content": tftypes.NewValue(tftypes.String, content),
+			}, provider.ConfigureProviderClientCapabilities{})
+			if resp.Diagnostics.HasError() {
+				t.Errorf("%s: unexpected diagnostics: %v", name, resp.Diagnostics)
+				return
+			}
+			clients[i] = resp.ResourceData.(*CiliumClient)
+		}(i, name)
+	}
+	wg.Wait()
+
+	for i, name := range clusters {
+		if clients[i] == nil {
+			t.Fatalf("%s: provider not configured", name)
+		}
+		k8sClient, err := clients[i].K8sClient()
+		if err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+		if k8sClient.Config.Host != servers[name].URL {
+			t.Errorf("%s: got host %q, want %q", name, k8sClient.Config.Host, servers[name].URL)
+		}
+		if k8sClient.ClusterName() != name {
+			t.Errorf("%s: got cluster name %q", name, k8sClient.ClusterName())
+		}
+		ns, err := k8sClient.GetNamespace(context.Background(), "kube-system", metav1.GetOptions{})
+		if err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+		if ns.Labels["cluster"] != name {
+			t.Errorf("%s: reached cluster %q", name, ns.Labels["cluster"])
+		}
+	}
+
+	if env, set := os.LookupEnv("KUBECONFIG"); env != kubeconfigEnv || set != kubeconfigSet {
+		t.Errorf("KUBECONFIG changed to %q", env)
+	}
+}
+
+// Each alias connects its cluster to a remote context of its own kubeconfig:
+// the remote clusters never come from the process environment.
+func TestProviderConfigureAliasesClusterMeshConnect(t *testing.T) {
+	upgrade := clustermeshHelmUpgrade
+	defer func() { clustermeshHelmUpgrade = upgrade }()
+	clustermeshHelmUpgrade = testClusterMeshUpgrade
+	t.Setenv("KUBECONFIG", "/nonexistent")
+
+	ca := testCACertificate(t)
+	clusters := []string{"kind-one", "kind-two"}
+	var wg sync.WaitGroup
+	for i, name := range clusters {
+		wg.Add(1)
+		go func(i int, name string) {
+			defer wg.Done()
+			remoteName := name + "-remote"
+			local := testFakeCluster(t, name, testClusterMeshRoutes(name, fmt.Sprint(2*i+1), fmt.Sprintf("10.0.0.%d", 2*i+1), ca))
+			remote := testFakeCluster(t, remoteName, testClusterMeshRoutes(remoteName, fmt.Sprint(2*i+2), fmt.Sprintf("10.0.0.%d", 2*i+2), ca))
+			resp := testProviderConfigure(t, map[string]tftypes.Value{
+				"config_content": tftypes.NewValue(tftypes.String, testKubeConfigContexts(t, [2]string{name, local.URL}, [2]string{remoteName, remote.URL})),
+				"helm_driver":    tftypes.NewValue(tftypes.String, "memory"),
+			}, provider.ConfigureProviderClientCapabilities{})
+			if resp.Diagnostics.HasError() {
+				t.Errorf("%s: unexpected diagnostics: %v", name, resp.Diagnostics)
+				return
+			}
+			c := resp.ResourceData.(*CiliumClient)
+			if _, err := c.K8sClient(); err != nil {
+				t.Errorf("%s: %s", name, err)
+				return
+			}
+			remoteClients, err := c.RemoteK8sClients([]string{remoteName})
+			if err != nil {
+				t.Errorf("%s: %s", name, err)
+				return
+			}
+			if remoteClients[0].Config.Host != remote.URL {
+				t.Errorf("%s: got remote host %s", name, remoteClients[0].Config.Host)
+			}
+			for _, client := range []*k8s.Client{c.client, remoteClients[0]} {
+				if err := client.HelmActionConfig.Releases.Create(&release.Release{
+					Name:      "cilium",
+					Namespace: "kube-system",
+					Version:   1,
+					Info:      &release.Info{Status: release.StatusDeployed},
+					Chart:     &chart.Chart{Metadata: &chart.Metadata{Name: "cilium", Version: "1.17.3"}},
+				}); err != nil {
+					t.Errorf("%s: %s", name, err)
+					return
+				}
+			}
+
+			params := clustermesh.Parameters{
+				Namespace:          "kube-system",
+				HelmReleaseName:    "cilium",
+				DestinationContext: []string{remoteName},
+				ConnectionMode:     "bidirectional",
+				Parallel:           1,
+			}
+			if err := c.ConnectClusterMesh(context.Background(), params); err != nil {
+				t.Errorf("%s: %s", name, err)
+				return
+			}
+			for client, connected := range map[*k8s.Client]string{c.client: remoteName, remoteClients[0]: name} {
+				last, err := client.HelmActionConfig.Releases.Last("cilium")
+				if err != nil {
+					t.Errorf("%s: %s", name, err)
+					return
+				}
+				clusters, _ := GetValue(last.Config, "clustermesh.config.clusters")
+				if l, ok := clusters.([]interface{}); !ok || len(l) != 1 || l[0].(map[string]interface{})["name"] != connected {
+					t.Errorf("%s: got clusters %v, want %s", name, clusters, connected)
+				}
+			}
+		}(i, name)
+	}
+	wg.Wait()
+}
+
+func TestProviderConfigureInvalidContent(t *testing.T) {
+	resp := testProviderConfigure(t, map[string]tftypes.Value{
+		"config_content": tftypes.NewValue(tftypes.String, "not: [a kube config"),
+	}, provider.ConfigureProviderClientCapabilities{})
+	if !resp.Diagnostics.HasError() {
+		t.Fatal("expected an error diagnostic")
+	}
+}
+
+func TestProviderConfigureUnknown(t *testing.T) {
+	unknownHost := map[string]tftypes.Value{
+		"host": tftypes.NewValue(tftypes.String, tftypes.UnknownValue),
+	}
+
+	resp := testProviderConfigure(t, unknownHost, provider.ConfigureProviderClientCapabilities{})
+	if resp.Diagnostics.HasError() {
+		t.Fatalf("unexpected diagnostics: %v", resp.Diagnostics)
+	}
+	if _, err := resp.ResourceData.(*CiliumClient).K8sClient(); !errors.Is(err, errProviderConfigUnknown) {
+		t.Errorf("got %v, want %v", err, errProviderConfigUnknown)
+	}
+
+	resp = testProviderConfigure(t, unknownHost, provider.ConfigureProviderClientCapabilities{DeferralAllowed: true})
+	if resp.Deferred == nil || resp.Deferred.Reason != provider.DeferredReasonProviderConfigUnknown {
+		t.Errorf("provider configuration should be deferred, got %v", resp.Deferred)
+	}
+}
+
+func TestProviderConfigureHelmDriver(t *testing.T) {
+	srv := testFakeCluster(t, "kind-memory", nil)
+	resp := testProviderConfigure(t, map[string]tftypes.Value{
+		"config_content": tftypes.NewValue(tftypes.String, testKubeConfig(srv.URL, "kind-memory")),
+		"helm_driver":    tftypes.NewValue(tftypes.String, "memory"),
+		"helm_namespace": tftypes.NewValue(tftypes.String, "helm-releases"),
+	}, provider.ConfigureProviderClientCapabilities{})
+	if resp.Diagnostics.HasError() {
+		t.Fatalf("unexpected diagnostics: %v", resp.Diagnostics)
+	}
+	c := resp.ResourceData.(*CiliumClient)
+	k8sClient, err := c.K8sClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mem, ok := k8sClient.HelmActionConfig.Releases.Driver.(*driver.Memory)
+	if !ok {
+		t.Fatalf("got driver %T", k8sClient.HelmActionConfig.Releases.Driver)
+	}
+	rel := &release.Release{
+		Name:      "cilium",
+		Namespace: "helm-releases",
+		Version:   1,
+		Info:      &release.Info{Status: release.StatusDeployed},
+		Chart:     &chart.Chart{Metadata: &chart.Metadata{Name: "cilium", Version: "1.17.3", AppVersion: "1.17.3"}},
+		Config:    map[string]interface{}{"debug": map[string]interface{}{"enabled": true}},
+	}
+	if err := mem.Create("sh.helm.release.v1.cilium.v1", rel); err != nil {
+		t.Fatal(err)
+	}
+
+	snapshot, err := c.GetReleaseSnapshot()
+	if err != nil {
+		t.Fatalf("release not found: %s", err)
+	}
+	if version := snapshot.Version(); version != "1.17.3" {
+		t.Errorf("got version %q", version)
+	}
+	values, err := snapshot.Values()
+	if err != nil || values != "debug:\n    enabled: true\n" {
+		t.Errorf("got values %q (%v)", values, err)
+	}
+}
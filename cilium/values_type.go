@@ -0,0 +1,94 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package provider
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/hashicorp/terraform-plugin-framework/attr"
+	"github.com/hashicorp/terraform-plugin-framework/diag"
+	"github.com/hashicorp/terraform-plugin-framework/types/basetypes"
+	"github.com/hashicorp/terraform-plugin-go/tftypes"
+)
+
+// Ensure the implementation satisfies the expected interfaces.
+var _ basetypes.StringTypable = ValuesType{}
+var _ basetypes.StringValuableWithSemanticEquals = ValuesValue{}
+
+// ValuesType is a yaml document of helm values. Two documents with the same
+// values (e.g. with the keys in another order) are semantically equal.
+type ValuesType struct {
+	basetypes.StringType
+}
+
+func (t ValuesType) Equal(o attr.Type) bool {
+	other, ok := o.(ValuesType)
+	if !ok {
+		return false
+	}
+	return t.StringType.Equal(other.StringType)
+}
+
+func (t ValuesType) String() string {
+	return "ValuesType"
+}
+
+func (t ValuesType) ValueFromString(ctx context.Context, in basetypes.StringValue) (basetypes.StringValuable, diag.Diagnostics) {
+	return ValuesValue{StringValue: in}, nil
+}
+
+func (t ValuesType) ValueFromTerraform(ctx context.Context, in tftypes.Value) (attr.Value, error) {
+	attrValue, err := t.StringType.ValueFromTerraform(ctx, in)
+	if err != nil {
+		return nil, err
+	}
+	stringValue, ok := attrValue.(basetypes.StringValue)
+	if !ok {
+		return nil, fmt.Errorf("unexpected value type of %T", attrValue)
+	}
+	stringValuable, diags := t.ValueFromString(ctx, stringValue)
+	if diags.HasError() {
+		return nil, fmt.Errorf("unexpected error converting StringValue to StringValuable: %v", diags)
+	}
+	return stringValuable, nil
+}
+
+func (t ValuesType) ValueType(ctx context.Context) attr.Value {
+	return ValuesValue{}
+}
+
+// ValuesValue is a value of ValuesType.
+type ValuesValue struct {
+	basetypes.StringValue
+}
+
+func NewValuesValue(value string) ValuesValue {
+	return ValuesValue{StringValue: basetypes.NewStringValue(value)}
+}
+
+func (v ValuesValue) Equal(o attr.Value) bool {
+	other, ok := o.(ValuesValue)
+	if !ok {
+		return false
+	}
+	return v.StringValue.Equal(other.StringValue)
+}
+
+func (v ValuesValue) Type(ctx context.Context) attr.Type {
+	return ValuesType{}
+}
+
+func (v ValuesValue) StringSemanticEquals(ctx context.Context, newValuable basetypes.StringValuable) (bool, diag.Diagnostics) {
+	var diags diag.Diagnostics
+	newValue, ok := newValuable.(ValuesValue)
+	if !ok {
+		diags.AddError(
+			"Semantic Equality Check Error",
+			fmt.Sprintf("Expected value type %T, got: %T. Please report this issue to the provider developers.", v, newValuable),
+		)
+		return false, diags
+	}
+	return SameValues(v.ValueString(), newValue.ValueString()), diags
+}
@@ -0,0 +1,30 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package provider
+
+import (
+	"context"
+	"testing"
+)
+
+func TestValuesValueSemanticEquals(t *testing.T) {
+	for name, tc := range map[string]struct {
+		a, b  string
+		equal bool
+	}{
+		"reordered":  {a: "ipam:\n  mode: kubernetes\ndebug:\n  enabled: true\n", b: "debug:\n    enabled: true\nipam:\n    mode: kubernetes\n", equal: true},
+		"flow style": {a: "ipam: {mode: kubernetes}\n", b: "ipam:\n    mode: kubernetes\n", equal: true},
+		"different":  {a: "ipam:\n  mode: kubernetes\n", b: "ipam:\n  mode: cluster-pool\n"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			equal, diags := NewValuesValue(tc.a).StringSemanticEquals(context.Background(), NewValuesValue(tc.b))
+			if diags.HasError() {
+				t.Fatal(diags)
+			}
+			if equal != tc.equal {
+				t.Errorf("got %t, expected %t", equal, tc.equal)
+			}
+		})
+	}
+}
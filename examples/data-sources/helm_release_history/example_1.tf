@@ -0,0 +1,5 @@
+data "cilium_helm_release_history" "example" {}
+
+output "previous_version" {
+  value = try(data.cilium_helm_release_history.example.revisions[length(data.cilium_helm_release_history.example.revisions) - 2].app_version, null)
+}
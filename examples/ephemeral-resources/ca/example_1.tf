@@ -0,0 +1,23 @@
+provider "cilium" {
+  store_ca_key = false
+}
+
+resource "cilium" "example" {
+  version = "1.16.1"
+}
+
+ephemeral "cilium_ca" "example" {
+  depends_on = [
+    cilium.example
+  ]
+}
+
+resource "vault_kv_secret_v2" "cilium_ca" {
+  mount = "secret"
+  name  = "cilium-ca"
+  data_json_wo = jsonencode({
+    crt = ephemeral.cilium_ca.example.crt
+    key = ephemeral.cilium_ca.example.key
+  })
+  data_json_wo_version = 1
+}
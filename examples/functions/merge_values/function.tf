@@ -0,0 +1,7 @@
+resource "cilium" "example" {
+  version = "1.16.1"
+  values = provider::cilium::merge_values(
+    file("${path.module}/values.yaml"),
+    yamlencode({ hubble = { relay = { enabled = true } } }),
+  )
+}
@@ -0,0 +1,10 @@
+locals {
+  set = ["ipam.mode=kubernetes", "operator.replicas=1"]
+}
+
+output "values" {
+  value = provider::cilium::merge_values(
+    file("${path.module}/values.yaml"),
+    provider::cilium::set_to_values(local.set),
+  )
+}
@@ -0,0 +1,8 @@
+resource "cilium" "example" {
+  version = "1.16.1"
+  set     = ["hubble.relay.enabled=true"]
+}
+
+output "relay_enabled" {
+  value = provider::cilium::values_get(cilium.example.helm_values, "hubble.relay.enabled")
+}
@@ -0,0 +1,30 @@
+resource "cilium" "example" {
+  version = "1.17.3"
+
+  values_object = {
+    ipam = {
+      mode = "kubernetes"
+    }
+    operator = {
+      replicas = 1
+    }
+  }
+
+  set_string = [
+    {
+      name  = "cluster.id"
+      value = "1"
+    },
+  ]
+
+  set_sensitive = [
+    {
+      name  = "hubble.tls.server.key"
+      value = var.hubble_server_key
+    },
+  ]
+}
+
+output "ipam_mode" {
+  value = cilium.example.helm_values_object.ipam.mode
+}
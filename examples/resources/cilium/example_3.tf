@@ -0,0 +1,11 @@
+# Chart copied next to the configuration, e.g. with `helm pull cilium/cilium --version 1.17.3`
+resource "cilium" "local" {
+  version    = "1.17.3"
+  chart_path = "${path.module}/charts/cilium-1.17.3.tgz"
+}
+
+# Chart mirrored in a private OCI registry
+resource "cilium" "mirror" {
+  version    = "1.17.3"
+  repository = "oci://registry.example.com/charts/cilium"
+}
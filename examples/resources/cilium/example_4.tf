@@ -0,0 +1,6 @@
+resource "cilium" "example" {
+  version    = "1.17.3"
+  chart_path = "${path.module}/charts/cilium-1.17.3.tgz" # cilium-1.17.3.tgz.prov next to it
+  verify     = true
+  keyring    = "${path.module}/keys/pubring.gpg"
+}
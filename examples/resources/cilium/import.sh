@@ -0,0 +1,2 @@
+# Release installed with `cilium install` or `helm install cilium cilium/cilium -n kube-system`
+terraform import cilium.this kube-system/cilium
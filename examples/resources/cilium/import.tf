@@ -0,0 +1,5 @@
+# terraform plan -generate-config-out=cilium.tf
+import {
+  to = cilium.this
+  id = "kube-system/cilium"
+}
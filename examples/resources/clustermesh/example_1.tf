@@ -1,7 +1,7 @@
 resource "cilium" "example" {
+  cluster_name = "clustermesh1"
+  cluster_id   = 1
   set = [
-    "cluster.name=clustermesh1",
-    "cluster.id=1",
     "ipam.mode=kubernetes",
   ]
   version = "1.14.5"
@@ -1,4 +1,5 @@
 resource "cilium_clustermesh_connection" "example" {
+  # context-2 is a context of the kubeconfig of the provider
   destination_contexts = ["context-2"]
 }
 